@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"service/config"
+	"service/log"
+)
+
+const Miss = Error("cache: miss")
+
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Cache stores encoded lookup results by key. Missing keys are returned as
+// nil values rather than errors. Remote backends give up when ctx is done.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, val []byte) error
+	// MGet returns the values of keys in order, with nil for every missing key.
+	MGet(ctx context.Context, keys []string) ([][]byte, error)
+	// MSet stores vals under keys.
+	MSet(ctx context.Context, keys []string, vals [][]byte) error
+	// Purge deletes every key starting with prefix.
+	Purge(ctx context.Context, prefix string) error
+	Close() error
+}
+
+var current Cache = none{}
+
+// Init creates the cache backend configured by 'cache.backend': "memory" for
+// an in-process LRU, "redis", "tiered" for memory in front of Redis, or "none".
+// It defaults to "redis" when 'redis.host' is set and to "memory" otherwise.
+func Init() error {
+	cfg := config.Get()
+	backend := cfg.GetString("cache.backend")
+	if backend == "" {
+		backend = "memory"
+		if cfg.GetString("redis.host") != "" {
+			backend = "redis"
+		}
+	}
+	expire, err := getDuration("cache.expire", "redis.expire", 5*time.Minute)
+	if err != nil {
+		return err
+	}
+	size := 100000
+	if cfg.IsSet("cache.size") {
+		size = cfg.GetInt("cache.size")
+		if size < 1 {
+			return fmt.Errorf("invalid cache size: %d", size)
+		}
+	}
+	switch backend {
+	case "none":
+		current = none{}
+	case "memory":
+		current = newMemory(size, expire)
+	case "redis":
+		current, err = newRedis(expire)
+		if err != nil {
+			return err
+		}
+	case "tiered":
+		remote, err := newRedis(expire)
+		if err != nil {
+			return err
+		}
+		localExpire, err := getDuration("cache.local_expire", "", expire)
+		if err != nil {
+			return err
+		}
+		current = &tiered{local: newMemory(size, localExpire), remote: remote}
+	default:
+		return fmt.Errorf("unknown cache backend: %s", backend)
+	}
+	log.Infof("Initialized cache: %s", backend)
+	return nil
+}
+
+func Deinit() {
+	current.Close()
+	current = none{}
+	client = nil
+}
+
+// Default returns the cache created by Init, which caches nothing until then.
+func Default() Cache {
+	return current
+}
+
+func Unmarshal(ctx context.Context, c Cache, key string, val interface{}) error {
+	data, err := c.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return Miss
+	}
+	return json.Unmarshal(data, val)
+}
+
+func Marshal(ctx context.Context, c Cache, key string, val interface{}) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return c.Set(ctx, key, data)
+}
+
+// getDuration reads the duration of key, or else of fallback, or else returns
+// def.
+func getDuration(key, fallback string, def time.Duration) (time.Duration, error) {
+	cfg := config.Get()
+	value := cfg.GetString(key)
+	if value == "" && fallback != "" {
+		key, value = fallback, cfg.GetString(fallback)
+	}
+	if value == "" {
+		return def, nil
+	}
+	du, err := time.ParseDuration(value)
+	if err != nil || du <= 0 {
+		log.Errorf("Invalid duration of '%s': %s", key, value)
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	return du, nil
+}
+
+// none caches nothing.
+type none struct{}
+
+func (none) Get(ctx context.Context, key string) ([]byte, error) { return nil, nil }
+
+func (none) Set(ctx context.Context, key string, val []byte) error { return nil }
+
+func (none) MGet(ctx context.Context, keys []string) ([][]byte, error) {
+	return make([][]byte, len(keys)), nil
+}
+
+func (none) MSet(ctx context.Context, keys []string, vals [][]byte) error { return nil }
+
+func (none) Purge(ctx context.Context, prefix string) error { return nil }
+
+func (none) Close() error { return nil }
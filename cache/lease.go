@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var release = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+var extend = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// Ready tells whether Init has connected to Redis, which the "redis" and
+// "tiered" backends do.
+func Ready() bool {
+	return client != nil
+}
+
+// Lease sets key to a random token for ttl unless it is set already, and
+// returns the token, or "" if someone else holds the lease. The lease expires
+// on its own if the holder dies before releasing it.
+func Lease(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	ok, err := client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", nil
+	}
+	return token, nil
+}
+
+// Release deletes the lease on key if it is still held with token.
+func Release(ctx context.Context, key, token string) error {
+	return release.Run(ctx, client, []string{key}, token).Err()
+}
+
+// Extend resets the TTL of the lease on key if it is still held with token,
+// and tells whether it was.
+func Extend(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	n, err := extend.Run(ctx, client, []string{key}, token, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+// Held tells whether the lease on key is still held by anyone.
+func Held(ctx context.Context, key string) (bool, error) {
+	n, err := client.Exists(ctx, key).Result()
+	return n > 0, err
+}
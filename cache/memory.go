@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"hash/maphash"
+	"strings"
+	"sync"
+	"time"
+)
+
+const shards = 64
+
+// memory is an in-process LRU cache whose entries also expire. It is split
+// into shards with their own lock so that concurrent requests rarely contend.
+type memory struct {
+	seed   maphash.Seed
+	shards [shards]*memoryShard
+}
+
+type memoryShard struct {
+	sync.Mutex
+	size    int
+	expire  time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type memoryEntry struct {
+	key     string
+	val     []byte
+	expires time.Time
+}
+
+func newMemory(size int, expire time.Duration) *memory {
+	m := &memory{seed: maphash.MakeSeed()}
+	shardSize := (size + shards - 1) / shards
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{
+			size:    shardSize,
+			expire:  expire,
+			entries: make(map[string]*list.Element),
+			lru:     list.New(),
+		}
+	}
+	return m
+}
+
+func (m *memory) shard(key string) *memoryShard {
+	return m.shards[maphash.String(m.seed, key)%shards]
+}
+
+func (m *memory) Get(ctx context.Context, key string) ([]byte, error) {
+	return m.shard(key).get(key, time.Now()), nil
+}
+
+func (m *memory) Set(ctx context.Context, key string, val []byte) error {
+	m.shard(key).set(key, val, time.Now())
+	return nil
+}
+
+func (m *memory) MGet(ctx context.Context, keys []string) ([][]byte, error) {
+	now := time.Now()
+	vals := make([][]byte, len(keys))
+	for i, key := range keys {
+		vals[i] = m.shard(key).get(key, now)
+	}
+	return vals, nil
+}
+
+func (m *memory) MSet(ctx context.Context, keys []string, vals [][]byte) error {
+	now := time.Now()
+	for i, key := range keys {
+		m.shard(key).set(key, vals[i], now)
+	}
+	return nil
+}
+
+func (m *memory) Purge(ctx context.Context, prefix string) error {
+	for _, s := range m.shards {
+		s.purge(prefix)
+	}
+	return nil
+}
+
+func (m *memory) Close() error {
+	return nil
+}
+
+func (s *memoryShard) get(key string, now time.Time) []byte {
+	s.Lock()
+	defer s.Unlock()
+	elem := s.entries[key]
+	if elem == nil {
+		return nil
+	}
+	entry := elem.Value.(*memoryEntry)
+	if now.After(entry.expires) {
+		s.lru.Remove(elem)
+		delete(s.entries, key)
+		return nil
+	}
+	s.lru.MoveToFront(elem)
+	return entry.val
+}
+
+func (s *memoryShard) set(key string, val []byte, now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	if elem := s.entries[key]; elem != nil {
+		entry := elem.Value.(*memoryEntry)
+		entry.val = val
+		entry.expires = now.Add(s.expire)
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(&memoryEntry{key: key, val: val, expires: now.Add(s.expire)})
+	for s.lru.Len() > s.size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+func (s *memoryShard) purge(prefix string) {
+	s.Lock()
+	defer s.Unlock()
+	for key, elem := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			s.lru.Remove(elem)
+			delete(s.entries, key)
+		}
+	}
+}
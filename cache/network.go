@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"net"
+	"sort"
+	"sync"
+)
+
+// Networks answers lookups of any address inside a network resolved before,
+// without touching the DB or the cache. The networks of a DB do not overlap,
+// so they are kept in one map per prefix length and a lookup probes the few
+// lengths in use. Entries belong to a version of the DB; storing one of
+// another version starts over, and so does running out of room.
+type Networks struct {
+	sync.RWMutex
+	size    int
+	count   int
+	version string
+	lengths []int
+	nets    map[int]map[[net.IPv6len]byte][]byte
+}
+
+// NewNetworks creates a table of up to size networks.
+func NewNetworks(size int) *Networks {
+	return &Networks{
+		size: size,
+		nets: make(map[int]map[[net.IPv6len]byte][]byte),
+	}
+}
+
+// Get returns the value of the network of version that ip belongs to, or nil.
+func (n *Networks) Get(version string, ip net.IP) []byte {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return nil
+	}
+	n.RLock()
+	defer n.RUnlock()
+	if n.version != version {
+		return nil
+	}
+	for _, length := range n.lengths {
+		if val, ok := n.nets[length][networkKey(ip16, length)]; ok {
+			return val
+		}
+	}
+	return nil
+}
+
+// Set stores val for network of version.
+func (n *Networks) Set(version string, network *net.IPNet, val []byte) {
+	ip16 := network.IP.To16()
+	ones, bits := network.Mask.Size()
+	if ip16 == nil || bits == 0 {
+		return
+	}
+	if bits == 8*net.IPv4len {
+		// IPv4 networks live in the IPv4-mapped range of the IPv6 space
+		ones += 8 * (net.IPv6len - net.IPv4len)
+	}
+	n.Lock()
+	defer n.Unlock()
+	if n.version != version || n.count >= n.size {
+		n.version = version
+		n.count = 0
+		n.lengths = nil
+		n.nets = make(map[int]map[[net.IPv6len]byte][]byte)
+	}
+	nets := n.nets[ones]
+	if nets == nil {
+		nets = make(map[[net.IPv6len]byte][]byte)
+		n.nets[ones] = nets
+		n.lengths = append(n.lengths, ones)
+		sort.Sort(sort.Reverse(sort.IntSlice(n.lengths)))
+	}
+	key := networkKey(ip16, ones)
+	if _, ok := nets[key]; !ok {
+		n.count++
+	}
+	nets[key] = val
+}
+
+// networkKey masks a 16 byte address to the prefix length.
+func networkKey(ip16 net.IP, length int) [net.IPv6len]byte {
+	var key [net.IPv6len]byte
+	copy(key[:], ip16.Mask(net.CIDRMask(length, 8*net.IPv6len)))
+	return key
+}
@@ -1,81 +1,221 @@
 package cache
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"service/config"
 	"service/log"
 
-	"github.com/go-redis/redis/v7"
+	"github.com/redis/go-redis/v9"
 )
 
-const Miss = Error("cache: miss")
+// client is shared by the Redis cache and the download leases.
+var client redis.UniversalClient
 
-type Error string
-
-func (e Error) Error() string { return string(e) }
-
-var client *redis.Client
-var expire time.Duration
+type redisCache struct {
+	client redis.UniversalClient
+	expire time.Duration
+}
 
-func Init() error {
-	cfg := config.Get()
-	var err error
-	redisOpts := &redis.Options{
-		Addr:     fmt.Sprintf("%s:%d", cfg.GetString("redis.host"), cfg.GetInt("redis.port")),
-		Password: cfg.GetString("redis.pass"),
+func newRedis(expire time.Duration) (*redisCache, error) {
+	opts, err := redisOptions()
+	if err != nil {
+		return nil, err
+	}
+	var c *redisCache
+	switch mode := config.Get().GetString("redis.mode"); mode {
+	case "", "standalone":
+		c = &redisCache{client: redis.NewClient(opts.Simple()), expire: expire}
+	case "sentinel":
+		if opts.MasterName == "" {
+			return nil, errors.New("missing 'redis.master' of sentinel")
+		}
+		c = &redisCache{client: redis.NewFailoverClient(opts.Failover()), expire: expire}
+	case "cluster":
+		c = &redisCache{client: redis.NewClusterClient(opts.Cluster()), expire: expire}
+	default:
+		return nil, fmt.Errorf("unknown redis mode: %s", mode)
 	}
-	client = redis.NewClient(redisOpts)
-	_, err = client.Ping().Result()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = c.client.Ping(ctx).Err()
 	if err != nil {
 		log.Errorf("Failed to ping redis: %s", err.Error())
-		return err
+		c.client.Close()
+		return nil, err
 	}
-	value := cfg.GetString("redis.expire")
-	expire, err = time.ParseDuration(value)
-	if err != nil {
-		log.Errorf("Invalid expire duration: %s", value)
-		return err
+	client = c.client
+	return c, nil
+}
+
+// redisOptions reads the connection settings under 'redis'. 'redis.addrs'
+// lists the sentinels or the cluster seed nodes, and defaults to 'redis.host'
+// and 'redis.port'.
+func redisOptions() (*redis.UniversalOptions, error) {
+	cfg := config.Get()
+	opts := &redis.UniversalOptions{
+		MasterName:       cfg.GetString("redis.master"),
+		Username:         cfg.GetString("redis.user"),
+		Password:         cfg.GetString("redis.pass"),
+		SentinelUsername: cfg.GetString("redis.sentinel_user"),
+		SentinelPassword: cfg.GetString("redis.sentinel_pass"),
+		DB:               cfg.GetInt("redis.db"),
+		PoolSize:         cfg.GetInt("redis.pool_size"),
+		MinIdleConns:     cfg.GetInt("redis.min_idle"),
+		// Let the deadline of the request bound the calls made for it.
+		ContextTimeoutEnabled: true,
+	}
+	for _, value := range cfg.GetStringSlice("redis.addrs") {
+		for _, addr := range strings.Split(value, ",") {
+			addr = strings.TrimSpace(addr)
+			if addr != "" {
+				opts.Addrs = append(opts.Addrs, addr)
+			}
+		}
+	}
+	if len(opts.Addrs) == 0 {
+		opts.Addrs = []string{fmt.Sprintf("%s:%d", cfg.GetString("redis.host"), cfg.GetInt("redis.port"))}
 	}
-	return nil
+	if cfg.GetBool("redis.tls.enabled") {
+		tlsConfig, err := redisTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+	return opts, nil
 }
 
-func Deinit() {
-	if client != nil {
-		client.Close()
-		client = nil
+// redisTLSConfig trusts the CA in 'redis.tls.ca' besides the system ones, and
+// presents the client certificate in 'redis.tls.cert' and 'redis.tls.key' if
+// set.
+func redisTLSConfig() (*tls.Config, error) {
+	cfg := config.Get()
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.GetString("redis.tls.server_name"),
+		InsecureSkipVerify: cfg.GetBool("redis.tls.insecure_skip_verify"),
+	}
+	if path := cfg.GetString("redis.tls.ca"); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", path)
+		}
+		tlsConfig.RootCAs = pool
 	}
+	cert, key := cfg.GetString("redis.tls.cert"), cfg.GetString("redis.tls.key")
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return tlsConfig, nil
+}
+
+func (c *redisCache) Close() error {
+	return c.client.Close()
 }
 
-func Get(key string) ([]byte, error) {
-	val, err := client.Get(key).Bytes()
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := c.client.Get(ctx, key).Bytes()
 	if err == redis.Nil {
 		return nil, nil
 	}
 	return val, err
 }
 
-func Set(key string, val []byte) error {
-	return client.Set(key, val, expire).Err()
+func (c *redisCache) Set(ctx context.Context, key string, val []byte) error {
+	return c.client.Set(ctx, key, val, c.expire).Err()
 }
 
-func Unmarshal(key string, val interface{}) error {
-	data, err := Get(key)
-	if err != nil {
-		return err
+// MGet queues one GET per key in a pipeline rather than using MGET, which a
+// cluster rejects for keys in different slots.
+func (c *redisCache) MGet(ctx context.Context, keys []string) ([][]byte, error) {
+	vals := make([][]byte, len(keys))
+	if len(keys) == 0 {
+		return vals, nil
 	}
-	if data == nil {
-		return Miss
+	pipe := c.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
 	}
-	return json.Unmarshal(data, val)
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		val, err := cmd.Bytes()
+		if err == nil {
+			vals[i] = val
+		}
+	}
+	return vals, nil
 }
 
-func Marshal(key string, val interface{}) error {
-	data, err := json.Marshal(val)
-	if err != nil {
-		return err
+// MSet stores vals under keys in a single pipeline. MSET itself cannot carry
+// an expiration, so one SET per key is queued instead.
+func (c *redisCache) MSet(ctx context.Context, keys []string, vals [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	pipe := c.client.Pipeline()
+	for i, key := range keys {
+		pipe.Set(ctx, key, vals[i], c.expire)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Purge deletes the keys starting with prefix in batches, scanning rather than
+// blocking Redis with KEYS. A cluster is scanned master by master.
+func (c *redisCache) Purge(ctx context.Context, prefix string) error {
+	if cluster, ok := c.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return purge(ctx, node, prefix)
+		})
+	}
+	return purge(ctx, c.client, prefix)
+}
+
+// purge unlinks the keys one by one in a pipeline since the keys of a batch
+// may belong to different cluster slots.
+func purge(ctx context.Context, client redis.UniversalClient, prefix string) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, prefix+"*", 1000).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			pipe := client.Pipeline()
+			for _, key := range keys {
+				pipe.Unlink(ctx, key)
+			}
+			_, err = pipe.Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
 	}
-	return Set(key, data)
 }
@@ -0,0 +1,68 @@
+package cache
+
+import "context"
+
+// tiered checks the local memory before Redis, and keeps what it fetches from
+// Redis locally as well.
+type tiered struct {
+	local  *memory
+	remote *redisCache
+}
+
+func (t *tiered) Get(ctx context.Context, key string) ([]byte, error) {
+	val, _ := t.local.Get(ctx, key)
+	if val != nil {
+		return val, nil
+	}
+	val, err := t.remote.Get(ctx, key)
+	if err != nil || val == nil {
+		return nil, err
+	}
+	t.local.Set(ctx, key, val)
+	return val, nil
+}
+
+func (t *tiered) Set(ctx context.Context, key string, val []byte) error {
+	t.local.Set(ctx, key, val)
+	return t.remote.Set(ctx, key, val)
+}
+
+func (t *tiered) MGet(ctx context.Context, keys []string) ([][]byte, error) {
+	vals, _ := t.local.MGet(ctx, keys)
+	missing := make([]string, 0)
+	indices := make([]int, 0)
+	for i, val := range vals {
+		if val == nil {
+			missing = append(missing, keys[i])
+			indices = append(indices, i)
+		}
+	}
+	if len(missing) == 0 {
+		return vals, nil
+	}
+	remote, err := t.remote.MGet(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for j, val := range remote {
+		if val != nil {
+			vals[indices[j]] = val
+			t.local.Set(ctx, missing[j], val)
+		}
+	}
+	return vals, nil
+}
+
+func (t *tiered) MSet(ctx context.Context, keys []string, vals [][]byte) error {
+	t.local.MSet(ctx, keys, vals)
+	return t.remote.MSet(ctx, keys, vals)
+}
+
+func (t *tiered) Purge(ctx context.Context, prefix string) error {
+	t.local.Purge(ctx, prefix)
+	return t.remote.Purge(ctx, prefix)
+}
+
+func (t *tiered) Close() error {
+	return t.remote.Close()
+}
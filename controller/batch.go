@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"service/config"
+	"service/db"
+	"service/log"
+
+	"github.com/gorilla/mux"
+)
+
+type query struct {
+	record string
+	prefix string
+	fn     func(ip net.IP) (interface{}, error)
+
+	lookups   atomic.Int64
+	coalesced atomic.Int64
+}
+
+var queries = map[string]*query{
+	"city": {
+		record: "city",
+		prefix: "city",
+		fn:     func(ip net.IP) (interface{}, error) { return db.QueryCity(ip) },
+	},
+	"country": {
+		record: "country",
+		prefix: "country",
+		fn:     func(ip net.IP) (interface{}, error) { return db.QueryCountry(ip) },
+	},
+	"asn": {
+		record: "asn",
+		prefix: "asn",
+		fn:     func(ip net.IP) (interface{}, error) { return db.QueryASN(ip) },
+	},
+	"anonymous-ip": {
+		record: "anonymous-ip",
+		prefix: "anonymous",
+		fn:     func(ip net.IP) (interface{}, error) { return db.QueryAnonymousIP(ip) },
+	},
+}
+
+const (
+	defaultBatchSize = 1000
+	batchLineSize    = 64
+)
+
+type batchError struct {
+	IP    string `json:"IP"`
+	Error string `json:"Error"`
+}
+
+func (c *GeoIPController) Batch(w http.ResponseWriter, r *http.Request) {
+	q := queries[mux.Vars(r)["record"]]
+	if q == nil {
+		http.Error(w, fmt.Sprintf("Unknown record type: %s", mux.Vars(r)["record"]), 404)
+		return
+	}
+	maxSize := defaultBatchSize
+	if size := config.Get().GetInt("batch.max_size"); size > 0 {
+		maxSize = size
+	}
+	// Bound the body before reading it, allowing for a quoted IPv6 address
+	// with some white space per line.
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxSize)*batchLineSize)
+	addrs, err := readAddresses(r)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, fmt.Sprintf("Request body too large: > %d bytes", maxBytesErr.Limit), 413)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %s", err.Error()), 400)
+		return
+	}
+	if len(addrs) > maxSize {
+		http.Error(w, fmt.Sprintf("Too many IP addresses: %d > %d", len(addrs), maxSize), 413)
+		return
+	}
+	// Answer what the network table can, and find the networks of the rest to
+	// look them up in the cache at once.
+	version := db.Version(q.record)
+	table := c.table(q)
+	results := make([]interface{}, len(addrs))
+	ips := make([]net.IP, len(addrs))
+	networks := make([]*net.IPNet, len(addrs))
+	versions := make([]string, len(addrs))
+	keys := make([]string, 0, len(addrs))
+	indices := make([]int, 0, len(addrs))
+	hits := 0
+	for i, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			results[i] = &batchError{IP: addr, Error: fmt.Sprintf("Invalid IP address: %s", addr)}
+			continue
+		}
+		ips[i] = ip
+		if table != nil {
+			if data := table.Get(version, ip); data != nil {
+				results[i] = withIP(data, ip)
+				hits++
+				continue
+			}
+		}
+		// Each address is keyed by the release that answered it, which may change
+		// in the middle of the batch.
+		network, version, err := db.Network(q.record, ip)
+		if err != nil {
+			results[i] = &batchError{IP: addr, Error: err.Error()}
+			continue
+		}
+		c.retire(q, version)
+		networks[i], versions[i] = network, version
+		keys = append(keys, c.cacheKey(q, version, network))
+		indices = append(indices, i)
+	}
+	vals, err := c.Cache.MGet(r.Context(), keys)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	// Misses are written back at once, and addresses of the same network
+	// share a lookup.
+	missKeys := make([]string, 0)
+	missVals := make([][]byte, 0)
+	resolved := make(map[string][]byte)
+	for j, i := range indices {
+		data := vals[j]
+		if data != nil {
+			hits++
+		} else if data = resolved[keys[j]]; data != nil {
+			q.coalesced.Add(1)
+		} else {
+			data, err = c.lookup(r.Context(), q, keys[j], ips[i], func(ctx context.Context, data []byte) error {
+				missKeys = append(missKeys, keys[j])
+				missVals = append(missVals, data)
+				return nil
+			})
+			if err != nil {
+				results[i] = &batchError{IP: addrs[i], Error: err.Error()}
+				continue
+			}
+			resolved[keys[j]] = data
+		}
+		if table != nil {
+			table.Set(versions[i], networks[i], data)
+		}
+		results[i] = withIP(data, ips[i])
+	}
+	log.Infof("Batch %s lookup: %d addresses, %d cache hits", q.prefix, len(addrs), hits)
+	err = c.Cache.MSet(r.Context(), missKeys, missVals)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, r, results)
+}
+
+// readAddresses parses the request body as either a JSON array of strings or
+// a newline delimited list of addresses.
+func readAddresses(r *http.Request) ([]string, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	addrs := make([]string, 0)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") || bytes.HasPrefix(data, []byte("[")) {
+		err = json.Unmarshal(data, &addrs)
+		if err != nil {
+			return nil, err
+		}
+		for i, addr := range addrs {
+			addrs[i] = strings.TrimSpace(addr)
+		}
+		return addrs, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		addr := strings.TrimSpace(scanner.Text())
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, scanner.Err()
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"service/db"
+
+	"github.com/gorilla/mux"
+)
+
+type DBController struct{}
+
+func (c *DBController) GetGenerations(w http.ResponseWriter, r *http.Request) {
+	generations, err := db.Generations(mux.Vars(r)["edition"])
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	writeJSON(w, r, generations)
+}
+
+func (c *DBController) Pin(w http.ResponseWriter, r *http.Request) {
+	value := stringVar(r, "generation", "")
+	epoch, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid generation: %s", value), 400)
+		return
+	}
+	err = db.Pin(mux.Vars(r)["edition"], uint(epoch))
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	c.GetGenerations(w, r)
+}
+
+func (c *DBController) Unpin(w http.ResponseWriter, r *http.Request) {
+	err := db.Unpin(mux.Vars(r)["edition"])
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	c.GetGenerations(w, r)
+}
+
+func writeDBError(w http.ResponseWriter, err error) {
+	switch err {
+	case db.ErrUnknownEdition, db.ErrUnknownGeneration:
+		http.Error(w, err.Error(), 404)
+	case db.ErrNoGenerations:
+		http.Error(w, err.Error(), 409)
+	default:
+		http.Error(w, err.Error(), 500)
+	}
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"context"
+	"net"
+	"sort"
+
+	"service/log"
+)
+
+// LookupStatus counts the cache misses of a record type that were looked up
+// in the DB, and those that waited for a concurrent lookup of the same network
+// instead.
+type LookupStatus struct {
+	Record    string
+	Lookups   int64
+	Coalesced int64
+}
+
+// lookup resolves a cache miss on key and writes the result with store.
+// Concurrent misses on the same key share the DB lookup and the cache write of
+// the first one. The write is detached from the request of the first one, and
+// failing it only costs a later miss, so it does not fail the others.
+func (c *GeoIPController) lookup(ctx context.Context, q *query, key string, ip net.IP, store func(ctx context.Context, data []byte) error) ([]byte, error) {
+	leader := false
+	v, err, _ := c.flights.Do(key, func() (interface{}, error) {
+		leader = true
+		q.lookups.Add(1)
+		data, err := c.resolve(q, ip)
+		if err != nil {
+			return nil, err
+		}
+		err = store(context.WithoutCancel(ctx), data)
+		if err != nil {
+			log.Errorf("Failed to cache %s: %s", key, err.Error())
+		}
+		return data, nil
+	})
+	if !leader {
+		q.coalesced.Add(1)
+	}
+	data, _ := v.([]byte)
+	return data, err
+}
+
+// Lookups reports the lookup counters of every record type.
+func Lookups() []*LookupStatus {
+	statuses := make([]*LookupStatus, 0, len(queries))
+	for _, q := range queries {
+		statuses = append(statuses, &LookupStatus{
+			Record:    q.record,
+			Lookups:   q.lookups.Load(),
+			Coalesced: q.coalesced.Load(),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Record < statuses[j].Record })
+	return statuses
+}
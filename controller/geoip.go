@@ -1,18 +1,48 @@
 package controller
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"sync"
+
 	"service/cache"
 	"service/db"
 	"service/log"
+
+	"golang.org/x/sync/singleflight"
 )
 
 type GeoIPController struct {
+	Cache cache.Cache
+	// Networks is the size of the table of resolved networks kept for each
+	// record type, or 0 to look up every address in the cache.
+	Networks int
+	versions sync.Map
+	tables   sync.Map
+	flights  singleflight.Group
 }
 
 func (c *GeoIPController) City(w http.ResponseWriter, r *http.Request) {
+	c.query(w, r, queries["city"])
+}
+
+func (c *GeoIPController) Country(w http.ResponseWriter, r *http.Request) {
+	c.query(w, r, queries["country"])
+}
+
+func (c *GeoIPController) ASN(w http.ResponseWriter, r *http.Request) {
+	c.query(w, r, queries["asn"])
+}
+
+func (c *GeoIPController) AnonymousIP(w http.ResponseWriter, r *http.Request) {
+	c.query(w, r, queries["anonymous-ip"])
+}
+
+func (c *GeoIPController) query(w http.ResponseWriter, r *http.Request, q *query) {
 	remoteAddr := stringVar(r, "ip", "")
 	if remoteAddr == "" {
 		remoteAddr = getRemoteAddress(r)
@@ -22,68 +52,143 @@ func (c *GeoIPController) City(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Invalid IP address: %s", remoteAddr), 400)
 		return
 	}
-	cacheKey := fmt.Sprintf("city:%s", remoteAddr)
-	var city db.City
-	err := cache.Unmarshal(cacheKey, &city)
-	if err == nil {
-		log.Infof("Hit city location cache: %s", remoteAddr)
-		writeJSON(w, r, &city)
+	table := c.table(q)
+	if table != nil {
+		if data := table.Get(db.Version(q.record), ip); data != nil {
+			log.Infof("Hit %s network table: %s", q.prefix, remoteAddr)
+			writeJSON(w, r, withIP(data, ip))
+			return
+		}
+	}
+	network, version, err := db.Network(q.record, ip)
+	if err == db.ErrNotFound {
+		c.notFound(w, r, q, ip)
+		return
+	} else if err != nil {
+		writeQueryError(w, err)
 		return
 	}
-	if err != cache.Miss {
+	c.retire(q, version)
+	cacheKey := c.cacheKey(q, version, network)
+	data, err := c.Cache.Get(r.Context(), cacheKey)
+	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
+	}
+	if data != nil {
+		log.Infof("Hit %s cache: %s => %s", q.prefix, remoteAddr, network)
 	} else {
-		log.Infof("Querying city location: %s", remoteAddr)
-		city, err := db.QueryCity(ip)
+		log.Infof("Querying %s: %s", q.prefix, remoteAddr)
+		data, err = c.lookup(r.Context(), q, cacheKey, ip, func(ctx context.Context, data []byte) error {
+			return c.Cache.Set(ctx, cacheKey, data)
+		})
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			writeQueryError(w, err)
 			return
 		}
-		err = cache.Marshal(cacheKey, city)
-		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-		writeJSON(w, r, city)
+	}
+	if table != nil {
+		table.Set(version, network, data)
+	}
+	writeJSON(w, r, withIP(data, ip))
+}
+
+// notFound writes the empty record of an address the DB has no record for,
+// which the single address endpoints serve rather than an error. It is not
+// cached since batches report such addresses as errors.
+func (c *GeoIPController) notFound(w http.ResponseWriter, r *http.Request, q *query, ip net.IP) {
+	data, err := c.resolve(q, ip)
+	if err != nil && err != db.ErrNotFound {
+		writeQueryError(w, err)
 		return
 	}
+	writeJSON(w, r, withIP(data, ip))
 }
 
-func (c *GeoIPController) Country(w http.ResponseWriter, r *http.Request) {
-	remoteAddr := stringVar(r, "ip", "")
-	if remoteAddr == "" {
-		remoteAddr = getRemoteAddress(r)
+// resolve looks up the record of ip in the DB and encodes it for the cache.
+// The empty record is returned along with db.ErrNotFound.
+func (c *GeoIPController) resolve(q *query, ip net.IP) ([]byte, error) {
+	res, err := q.fn(ip)
+	if err != nil && err != db.ErrNotFound {
+		return nil, err
 	}
-	ip := net.ParseIP(remoteAddr)
-	if ip == nil {
-		http.Error(w, fmt.Sprintf("Invalid IP address: %s", remoteAddr), 400)
-		return
+	data, merr := json.Marshal(res)
+	if merr != nil {
+		return nil, merr
 	}
-	cacheKey := fmt.Sprintf("country:%s", remoteAddr)
-	var country db.Country
-	err := cache.Unmarshal(cacheKey, &country)
-	if err == nil {
-		log.Infof("Hit country location cache: %s", remoteAddr)
-		writeJSON(w, r, &country)
-		return
+	return withoutIP(data), err
+}
+
+// retire records the version of the DB that answered the query. The first
+// request seeing a new version purges the cache entries of the old one in the
+// background.
+func (c *GeoIPController) retire(q *query, version string) {
+	last, loaded := c.versions.LoadOrStore(q.record, version)
+	if loaded && last.(string) != version && c.versions.CompareAndSwap(q.record, last, version) && last.(string) != "" {
+		go c.purge(fmt.Sprintf("%s:%s:", q.prefix, last))
 	}
-	if err != cache.Miss {
-		http.Error(w, err.Error(), 500)
-		return
+}
+
+// cacheKey namespaces the network by the version of the DB that answers the
+// query, so that swapping in a new release retires the results of the old one
+// at once. Every address of the network shares the entry.
+func (c *GeoIPController) cacheKey(q *query, version string, network *net.IPNet) string {
+	return fmt.Sprintf("%s:%s:%s", q.prefix, version, network)
+}
+
+// table returns the network table of the record type, if enabled.
+func (c *GeoIPController) table(q *query) *cache.Networks {
+	if c.Networks <= 0 {
+		return nil
+	}
+	table, ok := c.tables.Load(q.record)
+	if !ok {
+		table, _ = c.tables.LoadOrStore(q.record, cache.NewNetworks(c.Networks))
+	}
+	return table.(*cache.Networks)
+}
+
+func (c *GeoIPController) purge(prefix string) {
+	log.Infof("Purging outdated cache entries: %s*", prefix)
+	err := c.Cache.Purge(context.Background(), prefix)
+	if err != nil {
+		log.Errorf("Failed to purge cache: %s", err.Error())
+	}
+}
+
+// Cached records leave the leading IP field blank since they are shared by
+// every address of their network.
+var (
+	ipField      = []byte(`{"IP":"`)
+	blankIPField = []byte(`{"IP":""`)
+)
+
+func withoutIP(data []byte) []byte {
+	if !bytes.HasPrefix(data, ipField) {
+		return data
+	}
+	end := bytes.IndexByte(data[len(ipField):], '"')
+	if end < 0 {
+		return data
+	}
+	return append(append([]byte{}, blankIPField...), data[len(ipField)+end+1:]...)
+}
+
+func withIP(data []byte, ip net.IP) json.RawMessage {
+	if !bytes.HasPrefix(data, blankIPField) {
+		return data
+	}
+	res := make([]byte, 0, len(data)+net.IPv6len*3)
+	res = append(res, ipField...)
+	res = append(res, ip.String()...)
+	res = append(res, '"')
+	return append(res, data[len(blankIPField):]...)
+}
+
+func writeQueryError(w http.ResponseWriter, err error) {
+	if err == db.ErrNotFound {
+		http.Error(w, err.Error(), 404)
 	} else {
-		log.Infof("Querying country location: %s", remoteAddr)
-		country, err := db.QueryCountry(ip)
-		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-		err = cache.Marshal(cacheKey, country)
-		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-		writeJSON(w, r, country)
-		return
+		http.Error(w, err.Error(), 500)
 	}
 }
@@ -0,0 +1,17 @@
+package controller
+
+import (
+	"net/http"
+
+	"service/db"
+)
+
+type StatusController struct{}
+
+func (c *StatusController) GetStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, db.Status())
+}
+
+func (c *StatusController) GetLookupStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, Lookups())
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"service/log"
+
+	"github.com/gorilla/mux"
+)
+
+// maxStreamLine bounds the length of a line of a stream.
+const maxStreamLine = 64 * 1024
+
+// Stream reads an unbounded body of IP addresses or JSON objects, one per
+// line, and writes back one NDJSON line per input line as soon as it is
+// resolved. Objects get the fields of the record merged in, taking the address
+// from the field named by '?field=' (defaults to "ip"). Lines longer than
+// maxStreamLine are answered with an error. Lookups go straight to the DB
+// since a cache round trip per line would dominate the cost.
+func (c *GeoIPController) Stream(w http.ResponseWriter, r *http.Request) {
+	q := queries[mux.Vars(r)["record"]]
+	if q == nil {
+		http.Error(w, fmt.Sprintf("Unknown record type: %s", mux.Vars(r)["record"]), 404)
+		return
+	}
+	field := stringVar(r, "field", "ip")
+	rc := http.NewResponseController(w)
+	err := rc.EnableFullDuplex()
+	if err != nil {
+		log.Warnf("Failed to enable full duplex: %s", err.Error())
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(200)
+	br := bufio.NewReaderSize(r.Body, maxStreamLine)
+	count := 0
+	for {
+		line, err := br.ReadSlice('\n')
+		var res interface{}
+		if err == bufio.ErrBufferFull {
+			// Skip the rest of the line rather than buffering it.
+			for err == bufio.ErrBufferFull {
+				_, err = br.ReadSlice('\n')
+			}
+			res = &batchError{Error: fmt.Sprintf("Line exceeds %d bytes", maxStreamLine)}
+		} else if line = bytes.TrimSpace(line); len(line) > 0 {
+			res = enrich(q, line, field)
+		}
+		if res != nil {
+			data, merr := json.Marshal(res)
+			if merr != nil {
+				log.Errorf("Failed to marshal stream line: %s", merr.Error())
+				return
+			}
+			data = append(data, '\n')
+			_, werr := w.Write(data)
+			if werr != nil {
+				log.Warnf("Failed to write stream line: %s", werr.Error())
+				return
+			}
+			count++
+		}
+		// Flush whenever the next read would block so that the client sees
+		// results while it is still sending.
+		if br.Buffered() == 0 || err != nil {
+			_ = rc.Flush()
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("Failed to read stream: %s", err.Error())
+			return
+		}
+	}
+	log.Infof("Streamed %s lookup: %d lines", q.prefix, count)
+}
+
+func enrich(q *query, line []byte, field string) interface{} {
+	if line[0] != '{' {
+		addr := string(line)
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return &batchError{IP: addr, Error: fmt.Sprintf("Invalid IP address: %s", addr)}
+		}
+		res, err := q.fn(ip)
+		if err != nil {
+			return &batchError{IP: addr, Error: err.Error()}
+		}
+		return res
+	}
+	obj := make(map[string]json.RawMessage)
+	err := json.Unmarshal(line, &obj)
+	if err != nil {
+		return &batchError{Error: fmt.Sprintf("Invalid JSON object: %s", err.Error())}
+	}
+	var addr string
+	err = json.Unmarshal(obj[field], &addr)
+	if err != nil {
+		obj["Error"], _ = json.Marshal(fmt.Sprintf("Missing IP address field: %s", field))
+		return obj
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		obj["Error"], _ = json.Marshal(fmt.Sprintf("Invalid IP address: %s", addr))
+		return obj
+	}
+	res, err := q.fn(ip)
+	if err != nil {
+		obj["Error"], _ = json.Marshal(err.Error())
+		return obj
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		obj["Error"], _ = json.Marshal(err.Error())
+		return obj
+	}
+	fields := make(map[string]json.RawMessage)
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		obj["Error"], _ = json.Marshal(err.Error())
+		return obj
+	}
+	for k, v := range fields {
+		if _, exist := obj[k]; !exist {
+			obj[k] = v
+		}
+	}
+	return obj
+}
@@ -0,0 +1,343 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"service/config"
+	"service/log"
+	"service/storage"
+)
+
+// newCloudStorage creates the storage configured in 'geoip2.cloud_storage'
+// that replicas share downloaded DBs through, or returns nil.
+func newCloudStorage() storage.CloudStorage {
+	cfg := config.Get()
+	if !cfg.IsSet("geoip2.cloud_storage.provider") {
+		return nil
+	}
+	storageConfig := &storage.Config{
+		Provider:         cfg.GetString("geoip2.cloud_storage.provider"),
+		Bucket:           cfg.GetString("geoip2.cloud_storage.bucket"),
+		Region:           cfg.GetString("geoip2.cloud_storage.region"),
+		KeyPrefix:        cfg.GetString("geoip2.cloud_storage.key_prefix"),
+		Endpoint:         cfg.GetString("geoip2.cloud_storage.endpoint"),
+		PathStyle:        cfg.GetBool("geoip2.cloud_storage.path_style"),
+		Account:          cfg.GetString("geoip2.cloud_storage.account"),
+		ConnectionString: cfg.GetString("geoip2.cloud_storage.connection_string"),
+		SASToken:         cfg.GetString("geoip2.cloud_storage.sas_token"),
+		Path:             cfg.GetString("geoip2.cloud_storage.path"),
+		Timeout: storage.Timeouts{
+			Stat:     30 * time.Second,
+			Download: 5 * time.Minute,
+			Upload:   5 * time.Minute,
+		},
+	}
+	for key, du := range map[string]*time.Duration{
+		"geoip2.cloud_storage.timeout.stat":     &storageConfig.Timeout.Stat,
+		"geoip2.cloud_storage.timeout.download": &storageConfig.Timeout.Download,
+		"geoip2.cloud_storage.timeout.upload":   &storageConfig.Timeout.Upload,
+	} {
+		value := cfg.GetString(key)
+		if value == "" {
+			continue
+		}
+		var err error
+		*du, err = time.ParseDuration(value)
+		if err != nil || *du < 0 {
+			log.Errorf("Invalid duration of '%s': %s", key, value)
+			log.Warnf("Falling back to local storage")
+			return nil
+		}
+	}
+	cloudStorage, err := storage.NewCloudStorage(storageConfig)
+	if err != nil {
+		log.Errorf("Failed to initialize cloud storage: %s", err.Error())
+		log.Warnf("Falling back to local storage")
+		return nil
+	}
+	location := storageConfig.Bucket
+	if storageConfig.Path != "" {
+		location = storageConfig.Path
+	}
+	log.Infof("Initialized cloud storage: %s://%s", storageConfig.Provider, location)
+	return cloudStorage
+}
+
+// openFromCloudStorage opens a newer DB from cloud storage, if any, and
+// returns whether it did.
+func (db *geoIP2DB) openFromCloudStorage() bool {
+	etag, modTime := db.etag, db.modTime
+	path, err := db.loadFromCloudStorage()
+	if err != nil {
+		log.Warnf("Failed to load from cloud storage: %s", err.Error())
+		db.etag, db.modTime = etag, modTime
+		return false
+	}
+	if path == "" {
+		return false
+	}
+	_, err = db.openDatabase(path)
+	if err != nil {
+		log.Warnf("Rejected DB from cloud storage: %s", err.Error())
+		db.etag, db.modTime = etag, modTime
+		return false
+	}
+	return true
+}
+
+// loadFromCloudStorage downloads the release the pointer object of the edition
+// points to, or the object written before releases were versioned, unless it
+// has been loaded already.
+func (db *geoIP2DB) loadFromCloudStorage() (string, error) {
+	key := fmt.Sprintf("%s.mmdb", db.edition)
+	ptr, err := db.readCloudPointer()
+	if err != nil {
+		return "", err
+	}
+	if ptr != nil {
+		if ptr.ETag != "" && ptr.ETag == db.etag {
+			log.Infof("Cloud storage ETag unchanged: %s - skipping download", ptr.ETag)
+			return "", nil
+		}
+		key = ptr.Key
+	}
+
+	// Check if database exists in cloud storage and get its ETag
+	info, err := db.cloudStorage.Stat(db.ctx, key)
+	if err == storage.ErrObjectNotFound {
+		log.Infof("Database not found in cloud storage: %s", key)
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to check cloud storage: %w", err)
+	}
+
+	// Objects stored with a checksum must be complete
+	sum := info.Metadata["sha256"]
+	size := int64(-1)
+	if value, ok := info.Metadata["size"]; ok {
+		size, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid size in cloud storage metadata: %s", value)
+		}
+		if size != info.Size {
+			return "", fmt.Errorf("size mismatch in cloud storage: %d != %d", info.Size, size)
+		}
+	}
+
+	cloudETag := info.Metadata["etag"]
+	if cloudETag != "" {
+		// Check if ETag has changed since last load
+		if db.etag == cloudETag {
+			log.Infof("Cloud storage ETag unchanged: %s - skipping download", cloudETag)
+			return "", nil // No download needed
+		}
+
+		log.Infof("Found new ETag in cloud storage: %s (previous: %s)", cloudETag, db.etag)
+		db.etag = cloudETag
+	}
+
+	// Download database from cloud storage
+	reader, err := db.cloudStorage.Download(db.ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to download from cloud storage: %w", err)
+	}
+	defer reader.Close()
+
+	// Create temporary file
+	outfile, err := db.createTemp()
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer outfile.Close()
+
+	// Copy data to temporary file
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(outfile, hash), reader)
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("size mismatch: %d != %d", n, size)
+	}
+	if err == nil && sum != "" && hex.EncodeToString(hash.Sum(nil)) != sum {
+		err = fmt.Errorf("checksum mismatch: %s != %s", hex.EncodeToString(hash.Sum(nil)), sum)
+	}
+	if err != nil {
+		outfile.Close()
+		os.Remove(outfile.Name())
+		return "", fmt.Errorf("failed to copy data from cloud storage: %w", err)
+	}
+
+	db.modTime = info.Updated
+	if db.modTime.IsZero() {
+		db.modTime = time.Now()
+	}
+
+	log.Infof("Successfully loaded database from cloud storage: %s", outfile.Name())
+	return outfile.Name(), nil
+}
+
+// storeInCloudStorage uploads a release under its own key and then points the
+// pointer object of the edition at it, so that readers never see a partially
+// written release as current.
+func (db *geoIP2DB) storeInCloudStorage(localPath string, epoch uint) error {
+	key := db.cloudVersionKey(epoch)
+
+	// Open the local file
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer file.Close()
+
+	// Checksum the file so that readers can tell a complete copy
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+
+	// Prepare metadata with ETag
+	metadata := map[string]string{
+		"etag":          db.etag,
+		"edition":       db.edition,
+		"epoch":         strconv.FormatUint(uint64(epoch), 10),
+		"download_time": time.Now().Format(time.RFC3339),
+		"sha256":        hex.EncodeToString(hash.Sum(nil)),
+		"size":          strconv.FormatInt(size, 10),
+	}
+
+	// Upload to cloud storage
+	log.Infof("Storing database in cloud storage: %s", key)
+	err = db.cloudStorage.UploadWithMetadata(db.ctx, key, file, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to upload to cloud storage: %w", err)
+	}
+
+	ptr := &cloudPointer{Key: key, Epoch: epoch, ETag: db.etag}
+	data, err := json.Marshal(ptr)
+	if err != nil {
+		return err
+	}
+	err = db.cloudStorage.UploadWithMetadata(db.ctx, db.cloudPointerKey(), bytes.NewReader(data), nil)
+	if err != nil {
+		return fmt.Errorf("failed to update cloud storage pointer: %w", err)
+	}
+
+	log.Infof("Successfully stored database in cloud storage with ETag: %s", db.etag)
+	db.pruneCloudStorage(key)
+	return nil
+}
+
+// cloudPointer is stored as the current release of an edition
+type cloudPointer struct {
+	Key   string `json:"key"`
+	Epoch uint   `json:"epoch"`
+	ETag  string `json:"etag"`
+}
+
+// cloudVersionKey is where a release is stored, keyed by its build epoch.
+func (db *geoIP2DB) cloudVersionKey(epoch uint) string {
+	return fmt.Sprintf("%s/%d.mmdb", db.edition, epoch)
+}
+
+func (db *geoIP2DB) cloudPointerKey() string {
+	return fmt.Sprintf("%s/current.json", db.edition)
+}
+
+// readCloudPointer returns the pointer to the current release, or nil if
+// there is none yet.
+func (db *geoIP2DB) readCloudPointer() (*cloudPointer, error) {
+	reader, err := db.cloudStorage.Download(db.ctx, db.cloudPointerKey())
+	if err == storage.ErrObjectNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read cloud storage pointer: %w", err)
+	}
+	defer reader.Close()
+	ptr := &cloudPointer{}
+	err = json.NewDecoder(reader).Decode(ptr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cloud storage pointer: %w", err)
+	}
+	if ptr.Key == "" {
+		return nil, errors.New("invalid cloud storage pointer: missing key")
+	}
+	return ptr, nil
+}
+
+// cloudRetention limits the releases kept in cloud storage by number and by
+// age. Zero means no limit.
+type cloudRetention struct {
+	versions int
+	maxAge   time.Duration
+}
+
+func newCloudRetention() (*cloudRetention, error) {
+	cfg := config.Get()
+	r := &cloudRetention{versions: 10}
+	if cfg.IsSet("geoip2.cloud_storage.retention.versions") {
+		r.versions = cfg.GetInt("geoip2.cloud_storage.retention.versions")
+		if r.versions < 0 {
+			return nil, fmt.Errorf("invalid number of retained versions: %d", r.versions)
+		}
+	}
+	if value := cfg.GetString("geoip2.cloud_storage.retention.max_age"); value != "" {
+		var err error
+		r.maxAge, err = time.ParseDuration(value)
+		if err != nil || r.maxAge < 0 {
+			log.Errorf("Invalid retention age: %s", value)
+			return nil, fmt.Errorf("invalid duration: %s", value)
+		}
+	}
+	return r, nil
+}
+
+// pruneCloudStorage deletes the releases of the edition beyond the retention
+// policy, newest first, but never the current one.
+func (db *geoIP2DB) pruneCloudStorage(current string) {
+	if db.retention == nil || (db.retention.versions == 0 && db.retention.maxAge == 0) {
+		return
+	}
+	keys, err := db.cloudStorage.List(db.ctx, db.edition+"/")
+	if err != nil {
+		log.Warnf("Failed to list cloud storage: %s", err.Error())
+		return
+	}
+	epochs := make([]uint, 0, len(keys))
+	for _, key := range keys {
+		var epoch uint
+		_, err := fmt.Sscanf(key, db.edition+"/%d.mmdb", &epoch)
+		if err == nil && key == db.cloudVersionKey(epoch) {
+			epochs = append(epochs, epoch)
+		}
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i] > epochs[j] })
+	for i, epoch := range epochs {
+		key := db.cloudVersionKey(epoch)
+		if key == current {
+			continue
+		}
+		tooMany := db.retention.versions > 0 && i >= db.retention.versions
+		tooOld := db.retention.maxAge > 0 && time.Since(time.Unix(int64(epoch), 0)) > db.retention.maxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		log.Infof("Deleting outdated DB from cloud storage: %s", key)
+		err := db.cloudStorage.Delete(db.ctx, key)
+		if err != nil {
+			log.Warnf("Failed to delete from cloud storage: %s", err.Error())
+		}
+	}
+}
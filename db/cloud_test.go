@@ -0,0 +1,183 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"service/storage"
+)
+
+// statCounter counts the objects looked up in cloud storage.
+type statCounter struct {
+	storage.CloudStorage
+	stats int
+}
+
+func (s *statCounter) Stat(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	s.stats++
+	return s.CloudStorage.Stat(ctx, key)
+}
+
+func newCloudTestDB(t *testing.T, cloudStorage storage.CloudStorage) *geoIP2DB {
+	db := newGeoIP2DB("", "GeoLite2-City", "", nil, cloudStorage)
+	db.retention = &cloudRetention{}
+	t.Cleanup(db.close)
+	return db
+}
+
+func newMemoryStorage(t *testing.T) *statCounter {
+	memory, err := storage.NewMemoryStorage(&storage.Config{KeyPrefix: "geoip2/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &statCounter{CloudStorage: memory}
+}
+
+// storeTestDB stores a copy of the test DB as the release of epoch.
+func storeTestDB(t *testing.T, db *geoIP2DB, epoch uint, etag string) {
+	data, err := os.ReadFile(testDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.etag = etag
+	err = db.storeInCloudStorage(path, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func listCloudStorage(t *testing.T, cloudStorage storage.CloudStorage) []string {
+	keys, err := cloudStorage.List(context.Background(), "GeoLite2-City/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestCloudStorageRoundTrip(t *testing.T) {
+	cloudStorage := newMemoryStorage(t)
+	storeTestDB(t, newCloudTestDB(t, cloudStorage), 1700000000, `"v1"`)
+
+	db := newCloudTestDB(t, cloudStorage)
+	if !db.openFromCloudStorage() {
+		t.Fatal("DB not loaded from cloud storage")
+	}
+	if db.etag != `"v1"` {
+		t.Fatalf("etag = %s, want %s", db.etag, `"v1"`)
+	}
+
+	// The pointer still names the loaded release, so nothing is looked up.
+	stats := cloudStorage.stats
+	path, err := db.loadFromCloudStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "" {
+		t.Fatalf("loaded %s again", path)
+	}
+	if cloudStorage.stats != stats {
+		t.Fatalf("unchanged release looked up %d times", cloudStorage.stats-stats)
+	}
+}
+
+func TestCloudStorageRejectsCorruptRelease(t *testing.T) {
+	data, err := os.ReadFile(testDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := bytes.Clone(data)
+	flipped[0] ^= 0xff
+	for name, corrupt := range map[string][]byte{
+		"truncated": data[:len(data)/2],
+		"modified":  flipped,
+	} {
+		t.Run(name, func(t *testing.T) {
+			cloudStorage := newMemoryStorage(t)
+			storeTestDB(t, newCloudTestDB(t, cloudStorage), 1700000000, `"v1"`)
+
+			// Replace the release but keep the metadata of the original.
+			ctx := context.Background()
+			key := "GeoLite2-City/1700000000.mmdb"
+			info, err := cloudStorage.Stat(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cloudStorage.UploadWithMetadata(ctx, key, bytes.NewReader(corrupt), info.Metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = newCloudTestDB(t, cloudStorage).loadFromCloudStorage()
+			if err == nil {
+				t.Fatal("corrupt release downloaded")
+			}
+
+			db := newCloudTestDB(t, cloudStorage)
+			db.etag = `"v0"`
+			if db.openFromCloudStorage() {
+				t.Fatal("corrupt DB loaded from cloud storage")
+			}
+			// The ETag is kept so that the DB is downloaded from MaxMind.
+			if db.etag != `"v0"` {
+				t.Fatalf("etag = %s, want %s", db.etag, `"v0"`)
+			}
+			if db.reader.Load() != nil {
+				t.Fatal("corrupt DB opened")
+			}
+		})
+	}
+}
+
+func TestCloudStoragePrunesReleases(t *testing.T) {
+	cloudStorage := newMemoryStorage(t)
+	db := newCloudTestDB(t, cloudStorage)
+	db.retention = &cloudRetention{versions: 2}
+	for epoch := uint(1700000001); epoch <= 1700000004; epoch++ {
+		storeTestDB(t, db, epoch, `"v"`)
+	}
+	want := []string{
+		"GeoLite2-City/1700000003.mmdb",
+		"GeoLite2-City/1700000004.mmdb",
+		"GeoLite2-City/current.json",
+	}
+	if keys := listCloudStorage(t, cloudStorage); !slices.Equal(keys, want) {
+		t.Fatalf("kept %v, want %v", keys, want)
+	}
+
+	ptr, err := db.readCloudPointer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ptr.Key != "GeoLite2-City/1700000004.mmdb" {
+		t.Fatalf("pointer = %s", ptr.Key)
+	}
+}
+
+func TestCloudStoragePrunesOldReleases(t *testing.T) {
+	cloudStorage := newMemoryStorage(t)
+	db := newCloudTestDB(t, cloudStorage)
+	db.retention = &cloudRetention{maxAge: time.Hour}
+	old := uint(time.Now().Add(-2 * time.Hour).Unix())
+	recent := uint(time.Now().Unix())
+	storeTestDB(t, db, old-1, `"v1"`)
+	// The current release is kept however old it is.
+	storeTestDB(t, db, old, `"v2"`)
+	if keys := listCloudStorage(t, cloudStorage); len(keys) != 2 || keys[0] != db.cloudVersionKey(old) {
+		t.Fatalf("kept %v", keys)
+	}
+	storeTestDB(t, db, recent, `"v3"`)
+	want := []string{db.cloudVersionKey(recent), "GeoLite2-City/current.json"}
+	if keys := listCloudStorage(t, cloudStorage); !slices.Equal(keys, want) {
+		t.Fatalf("kept %v, want %v", keys, want)
+	}
+}
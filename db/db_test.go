@@ -0,0 +1,35 @@
+package db
+
+import (
+	"os"
+	"testing"
+
+	"service/config"
+	"service/log"
+)
+
+// testDB is a small GeoLite2-City DB holding 1.1.1.0/24, 8.8.8.0/24 and
+// 81.2.69.0/24.
+const testDB = "testdata/GeoLite2-City-Test.mmdb"
+
+func TestMain(m *testing.M) {
+	err := config.Init("local")
+	if err == nil {
+		err = log.Init()
+	}
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// openTestDB serves the test DB until the test is done.
+func openTestDB(tb testing.TB) {
+	db := newFileDB("GeoLite2-City", testDB)
+	err := db.reload()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dbs = []*geoIP2DB{db}
+	tb.Cleanup(Deinit)
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"service/config"
+	"service/log"
+)
+
+const defaultDownloadURL = "https://download.maxmind.com/app/geoip_download"
+
+// downloader fetches DB archives from MaxMind or a mirror of its download API.
+type downloader struct {
+	client    *http.Client
+	url       string
+	userAgent string
+	checksum  bool
+}
+
+func newDownloader() (*downloader, error) {
+	cfg := config.Get()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	proxy := cfg.GetString("geoip2.download.proxy")
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			log.Errorf("Invalid download proxy: %s", proxy)
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	caFile := cfg.GetString("geoip2.download.ca_file")
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			log.Errorf("Failed to read CA file: %s", err.Error())
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Errorf("No certificate found in CA file: %s", caFile)
+			return nil, errors.New("invalid CA file")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	timeout, err := getDuration("geoip2.download.timeout", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	d := &downloader{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+		url:       cfg.GetString("geoip2.download.url"),
+		userAgent: cfg.GetString("geoip2.download.user_agent"),
+		checksum:  !cfg.IsSet("geoip2.download.checksum") || cfg.GetBool("geoip2.download.checksum"),
+	}
+	if d.url == "" {
+		d.url = defaultDownloadURL
+	}
+	if d.userAgent == "" {
+		d.userAgent = "geoipd"
+	}
+	return d, nil
+}
+
+// get requests the archive of edition with the given suffix, e.g. "tar.gz".
+// The license key is redacted from returned errors since they end up in logs.
+// Cancelling ctx aborts the request, including the transfer of the body.
+func (d *downloader) get(ctx context.Context, edition, licenseKey, suffix, etag string) (*http.Response, error) {
+	query := url.Values{}
+	query.Set("edition_id", edition)
+	query.Set("license_key", licenseKey)
+	query.Set("suffix", suffix)
+	sep := "?"
+	if strings.Contains(d.url, "?") {
+		sep = "&"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", d.url+sep+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", redact(err, licenseKey))
+	}
+	req.Header.Set("User-Agent", d.userAgent)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	res, err := d.client.Do(req)
+	if err != nil {
+		return nil, redact(err, licenseKey)
+	}
+	return res, nil
+}
+
+func redact(err error, secret string) error {
+	var urlErr *url.Error
+	if secret != "" && errors.As(err, &urlErr) {
+		urlErr.URL = strings.ReplaceAll(urlErr.URL, url.QueryEscape(secret), "REDACTED")
+	}
+	return err
+}
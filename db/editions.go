@@ -0,0 +1,162 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"service/config"
+)
+
+type editionConfig struct {
+	Name     string
+	Renew    string
+	Path     string
+	Canaries []net.IP
+}
+
+// getEditions reads 'geoip2.editions', which may be a list of edition names,
+// a list of {name, renew, path, canaries} maps or a comma separated string
+// (e.g. from the GEOIP2_EDITIONS environment variable). A single
+// 'geoip2.edition' (e.g. from GEOIP2_EDITION) is used when no list is
+// configured.
+//
+// Editions with a path are read from that local file instead of being
+// downloaded. 'geoip2.path' sets the path of every edition: a directory holds
+// one '<edition>.mmdb' per edition, and all DBs in it are served if no edition
+// is configured.
+func getEditions() ([]*editionConfig, error) {
+	cfg := config.Get()
+	renew := cfg.GetString("geoip2.renew")
+	editions := make([]*editionConfig, 0)
+	switch value := cfg.Get("geoip2.editions").(type) {
+	case nil:
+	case string:
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				editions = append(editions, &editionConfig{Name: name, Renew: renew})
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			edition, err := parseEdition(item, renew)
+			if err != nil {
+				return nil, err
+			}
+			editions = append(editions, edition)
+		}
+	default:
+		return nil, fmt.Errorf("invalid editions: %v", value)
+	}
+	if len(editions) == 0 && cfg.GetString("geoip2.edition") != "" {
+		editions = append(editions, &editionConfig{Name: cfg.GetString("geoip2.edition"), Renew: renew})
+	}
+	path := cfg.GetString("geoip2.path")
+	if path == "" {
+		if len(editions) == 0 {
+			return nil, fmt.Errorf("no edition configured")
+		}
+		return editions, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		if len(editions) > 1 {
+			return nil, fmt.Errorf("'geoip2.path' is a file but %d editions are configured", len(editions))
+		}
+		if len(editions) == 0 {
+			name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			editions = append(editions, &editionConfig{Name: name, Renew: renew})
+		}
+		if editions[0].Path == "" {
+			editions[0].Path = path
+		}
+		return editions, nil
+	}
+	if len(editions) == 0 {
+		files, err := filepath.Glob(filepath.Join(path, "*.mmdb"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(filepath.Base(file), ".mmdb")
+			editions = append(editions, &editionConfig{Name: name, Renew: renew})
+		}
+		if len(editions) == 0 {
+			return nil, fmt.Errorf("no DB found in %s", path)
+		}
+	}
+	for _, edition := range editions {
+		if edition.Path == "" {
+			edition.Path = filepath.Join(path, fmt.Sprintf("%s.mmdb", edition.Name))
+		}
+	}
+	return editions, nil
+}
+
+// parseEdition reads an item of the 'geoip2.editions' list, which is either
+// the name of the edition or a map of its settings. Maps decoded from YAML may
+// have keys of any type, so their keys are turned into strings first.
+func parseEdition(item interface{}, renew string) (*editionConfig, error) {
+	edition := &editionConfig{Renew: renew}
+	var settings map[string]interface{}
+	switch item := item.(type) {
+	case string:
+		edition.Name = item
+	case map[string]interface{}:
+		settings = item
+	case map[interface{}]interface{}:
+		settings = make(map[string]interface{}, len(item))
+		for k, v := range item {
+			settings[mapString(k)] = v
+		}
+	default:
+		return nil, fmt.Errorf("invalid edition: %v", item)
+	}
+	if settings != nil {
+		edition.Name = mapString(settings["name"])
+		edition.Path = mapString(settings["path"])
+		if v, ok := settings["renew"]; ok {
+			edition.Renew = mapString(v)
+		}
+		canaries, err := mapIPs(settings["canaries"])
+		if err != nil {
+			return nil, err
+		}
+		edition.Canaries = canaries
+	}
+	if edition.Name == "" {
+		return nil, fmt.Errorf("missing edition name: %v", item)
+	}
+	return edition, nil
+}
+
+func mapIPs(v interface{}) ([]net.IP, error) {
+	if v == nil {
+		return nil, nil
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid IP list: %v", v)
+	}
+	ips := make([]net.IP, len(items))
+	for i, item := range items {
+		ips[i] = net.ParseIP(mapString(item))
+		if ips[i] == nil {
+			return nil, fmt.Errorf("invalid IP address: %v", item)
+		}
+	}
+	return ips, nil
+}
+
+func mapString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+	"os"
+
+	"service/log"
+)
+
+// newFileDB creates a DB that is read from a local file, such as one kept up
+// to date by geoipupdate, instead of being downloaded from MaxMind. It is
+// polled every 'geoip2.poll' and reopened whenever its modification time
+// changes.
+func newFileDB(edition, file string) *geoIP2DB {
+	return &geoIP2DB{
+		edition: edition,
+		file:    file,
+	}
+}
+
+// reload opens the DB file if it has been modified since it was last opened.
+func (db *geoIP2DB) reload() error {
+	info, err := os.Stat(db.file)
+	if err != nil {
+		log.Errorf("Failed to stat DB file: %s", err.Error())
+		return err
+	}
+	if info.ModTime().Equal(db.modTime) {
+		return nil
+	}
+	log.Infof("DB file modified: %s => %s", db.file, info.ModTime().String())
+	modTime := db.modTime
+	db.modTime = info.ModTime()
+	_, err = db.openDatabase(db.file)
+	if err != nil {
+		db.modTime = modTime
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"service/log"
+)
+
+var (
+	// ErrUnknownEdition is returned when no DB of the given edition is loaded.
+	ErrUnknownEdition = errors.New("unknown edition")
+
+	// ErrUnknownGeneration is returned when the given generation is not kept.
+	ErrUnknownGeneration = errors.New("unknown generation")
+
+	// ErrNoGenerations is returned for editions that do not keep generations.
+	ErrNoGenerations = errors.New("generations are only kept for downloaded editions with 'geoip2.data_dir'")
+)
+
+type Generation struct {
+	Epoch   uint
+	Built   string
+	ETag    string
+	Updated string
+	Current bool
+	Pinned  bool
+}
+
+func findDB(edition string) (*geoIP2DB, error) {
+	for _, db := range dbs {
+		if db.edition == edition {
+			if db.file != "" || db.dataDir == "" {
+				return nil, ErrNoGenerations
+			}
+			return db, nil
+		}
+	}
+	return nil, ErrUnknownEdition
+}
+
+// Generations lists the kept generations of edition from newest to oldest.
+func Generations(edition string) ([]*Generation, error) {
+	db, err := findDB(edition)
+	if err != nil {
+		return nil, err
+	}
+	db.Lock()
+	defer db.Unlock()
+	var current uint
+	if reader := db.reader.Load(); reader != nil {
+		current = reader.Metadata.BuildEpoch
+	}
+	generations := make([]*Generation, len(db.generations))
+	for i, g := range db.generations {
+		generations[i] = &Generation{
+			Epoch:   g.Epoch,
+			Built:   time.Unix(int64(g.Epoch), 0).UTC().Format(time.RFC1123),
+			ETag:    g.ETag,
+			Updated: g.ModTime.Format(time.RFC1123),
+			Current: g.Epoch == current,
+			Pinned:  g.Epoch == db.pinned,
+		}
+	}
+	return generations, nil
+}
+
+// Pin rolls edition back, or forward, to the generation built at epoch and
+// keeps it there, skipping renews, until Unpin is called.
+func Pin(edition string, epoch uint) error {
+	db, err := findDB(edition)
+	if err != nil {
+		return err
+	}
+	db.Lock()
+	defer db.Unlock()
+	g := db.findGeneration(epoch)
+	if g == nil {
+		return ErrUnknownGeneration
+	}
+	err = db.openGeneration(g)
+	if err != nil {
+		return err
+	}
+	db.pinned = epoch
+	log.Warnf("Pinned %s to generation %d", edition, epoch)
+	return db.saveState()
+}
+
+// Unpin reopens the newest generation of edition and resumes renews.
+func Unpin(edition string) error {
+	db, err := findDB(edition)
+	if err != nil {
+		return err
+	}
+	db.Lock()
+	defer db.Unlock()
+	if db.pinned == 0 {
+		return nil
+	}
+	if len(db.generations) > 0 {
+		err = db.openGeneration(db.generations[0])
+		if err != nil {
+			return err
+		}
+	}
+	db.pinned = 0
+	log.Infof("Unpinned %s", edition)
+	return db.saveState()
+}
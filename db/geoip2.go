@@ -3,287 +3,376 @@ package db
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net"
-	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"service/config"
 	"service/log"
 	"service/storage"
 
-	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/robfig/cron/v3"
 )
 
-var db *geoIP2DB
-var ticker *time.Ticker
-var done chan bool
+var dbs []*geoIP2DB
 
 func Init() error {
 	cfg := config.Get()
-	key := cfg.GetString("geoip2.license_key")
-	if key == "" {
-		log.Errorf("Please specify 'geoip2.license_key' in YAML config or set GEOIP2_LICENSE_KEY environment variable in order to download DB.")
-		return errors.New("missing license key")
-	}
-	edition := cfg.GetString("geoip2.edition")
-	db = newGeoIP2DB(key, edition)
-	err := db.renew()
+	editions, err := getEditions()
 	if err != nil {
+		log.Errorf("Invalid editions: %s", err.Error())
 		return err
 	}
-	renew := cfg.GetString("geoip2.renew")
-	if renew != "" {
-		du, err := time.ParseDuration(renew)
+	key := cfg.GetString("geoip2.license_key")
+	for _, edition := range editions {
+		if edition.Path == "" && key == "" {
+			log.Errorf("Please specify 'geoip2.license_key' in YAML config or set GEOIP2_LICENSE_KEY environment variable in order to download DB.")
+			return errors.New("missing license key")
+		}
+	}
+	dataDir := cfg.GetString("geoip2.data_dir")
+	if dataDir != "" {
+		err := os.MkdirAll(dataDir, 0755)
 		if err != nil {
-			log.Errorf("Invalid renew duration: %s", renew)
+			log.Errorf("Failed to create data directory: %s", err.Error())
 			return err
 		}
-		log.Infof("Scheduling DB renew every %s", renew)
-		ticker = time.NewTicker(du)
-		done = make(chan bool)
-		go func() {
-			for {
-				select {
-				case <-done:
-					return
-				case t := <-ticker.C:
-					log.Infof("Renewing DB at %s", t.String())
-					_ = db.renew()
-				}
-			}
-		}()
 	}
-	return nil
-}
-
-func Deinit() {
-	if ticker != nil {
-		ticker.Stop()
-		done <- true
-		ticker = nil
-	}
-	if db != nil {
-		if db.reader != nil {
-			db.reader.Close()
-			db.reader = nil
-		}
-		if db.path != "" {
-			os.Remove(db.path)
+	keep := 3
+	if cfg.IsSet("geoip2.generations") {
+		keep = cfg.GetInt("geoip2.generations")
+		if keep < 1 {
+			return fmt.Errorf("invalid number of generations: %d", keep)
 		}
-		db = nil
 	}
-}
-
-type City struct {
-	IP      string `json:"IP"`
-	Updated string `json:"Updated,omitempty"`
-	*geoip2.City
-}
-
-func QueryCity(ip net.IP) (*City, error) {
-	db.Lock()
-	defer db.Unlock()
-	res, err := db.reader.City(ip)
+	retry, err := newBackoff()
+	if err != nil {
+		return err
+	}
+	splay, err := getDuration("geoip2.splay", 0)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &City{
-		City:    res,
-		IP:      ip.String(),
-		Updated: db.modTime.Format(time.RFC1123),
-	}, nil
-}
-
-type Country struct {
-	IP      string `json:"IP"`
-	Updated string `json:"Updated,omitempty"`
-	*geoip2.Country
+	poll, err := getDuration("geoip2.poll", time.Minute)
+	if err != nil {
+		return err
+	}
+	leaseTTL, err := getLeaseTTL()
+	if err != nil {
+		return err
+	}
+	var dl *downloader
+	var cloudStorage storage.CloudStorage
+	var retention *cloudRetention
+	for _, edition := range editions {
+		var db *geoIP2DB
+		if edition.Path != "" {
+			db = newFileDB(edition.Name, edition.Path)
+		} else {
+			if cloudStorage == nil {
+				cloudStorage = newCloudStorage()
+				if cloudStorage != nil {
+					retention, err = newCloudRetention()
+					if err != nil {
+						Deinit()
+						return err
+					}
+				}
+			}
+			if dl == nil {
+				dl, err = newDownloader()
+				if err != nil {
+					Deinit()
+					return err
+				}
+			}
+			db = newGeoIP2DB(key, edition.Name, dataDir, dl, cloudStorage)
+			db.keep = keep
+			db.retention = retention
+			if cloudStorage != nil && leaseTTL > 0 {
+				db.lease = &lease{
+					key: fmt.Sprintf("lease:%s", edition.Name),
+					ttl: leaseTTL,
+				}
+			}
+		}
+		db.canaries = edition.Canaries
+		db.retry = retry
+		dbs = append(dbs, db)
+		// Local files are polled on their own, since whatever updates them
+		// does not follow the MaxMind renew schedule.
+		var schedule cron.Schedule
+		if edition.Path != "" {
+			schedule = every(poll)
+			db.renewSpec = poll.String()
+		} else if edition.Renew != "" {
+			schedule, err = parseSchedule(edition.Renew, splay)
+			if err != nil {
+				log.Errorf("Invalid renew schedule: %s", edition.Renew)
+				Deinit()
+				return err
+			}
+			db.renewSpec = edition.Renew
+		}
+		persisted, err := db.start()
+		if err != nil {
+			Deinit()
+			return err
+		}
+		if schedule != nil {
+			log.Infof("Scheduling %s renew: %s", edition.Name, db.renewSpec)
+		}
+		db.schedule(schedule, persisted)
+	}
+	return nil
 }
 
-func QueryCountry(ip net.IP) (*Country, error) {
-	db.Lock()
-	defer db.Unlock()
-	res, err := db.reader.Country(ip)
-	if err != nil {
-		return nil, err
+func Deinit() {
+	for _, db := range dbs {
+		db.close()
 	}
-	return &Country{
-		Country: res,
-		IP:      ip.String(),
-		Updated: db.modTime.Format(time.RFC1123),
-	}, nil
+	dbs = nil
 }
 
 type geoIP2DB struct {
 	sync.Mutex
 	licenseKey   string
 	edition      string
+	file         string
+	dataDir      string
 	etag         string
 	modTime      time.Time
-	path         string
-	reader       *geoip2.Reader
+	reader       atomic.Pointer[mmdbReader]
+	downloader   *downloader
+	canaries     []net.IP
+	keep         int
+	generations  []*generation
+	pinned       uint
 	cloudStorage storage.CloudStorage
+	retention    *cloudRetention
+	ctx          context.Context
+	cancel       context.CancelFunc
+	renewSpec    string
+	lease        *lease
+	retry        *backoff
+	done         chan struct{}
+	wg           sync.WaitGroup
+	statusLock   sync.Mutex
+	status       renewStatus
 }
 
-func newGeoIP2DB(licenseKey, edition string) *geoIP2DB {
-	cfg := config.Get()
-	var cloudStorage storage.CloudStorage
-
-	// Initialize cloud storage if configured
-	if cfg.IsSet("geoip2.cloud_storage.provider") {
-		storageConfig := &storage.Config{
-			Provider:  cfg.GetString("geoip2.cloud_storage.provider"),
-			Bucket:    cfg.GetString("geoip2.cloud_storage.bucket"),
-			Region:    cfg.GetString("geoip2.cloud_storage.region"),
-			KeyPrefix: cfg.GetString("geoip2.cloud_storage.key_prefix"),
-		}
-
-		var err error
-		cloudStorage, err = storage.NewCloudStorage(storageConfig)
-		if err != nil {
-			log.Errorf("Failed to initialize cloud storage: %s", err.Error())
-			log.Warnf("Falling back to local storage")
-			cloudStorage = nil
-		} else {
-			log.Infof("Initialized cloud storage: %s://%s", storageConfig.Provider, storageConfig.Bucket)
-		}
+// getDuration reads the duration of key, or returns def if it is not set.
+func getDuration(key string, def time.Duration) (time.Duration, error) {
+	value := config.Get().GetString(key)
+	if value == "" {
+		return def, nil
 	}
+	du, err := time.ParseDuration(value)
+	if err != nil || du <= 0 {
+		log.Errorf("Invalid duration of '%s': %s", key, value)
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	return du, nil
+}
 
+func newGeoIP2DB(licenseKey, edition, dataDir string, downloader *downloader, cloudStorage storage.CloudStorage) *geoIP2DB {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &geoIP2DB{
 		licenseKey:   licenseKey,
 		edition:      edition,
+		dataDir:      dataDir,
+		downloader:   downloader,
 		cloudStorage: cloudStorage,
+		ctx:          ctx,
+		cancel:       cancel,
 	}
 }
 
-func (db *geoIP2DB) renew() error {
-	// If cloud storage is configured, try to load from there first
-	if db.cloudStorage != nil {
-		path, err := db.loadFromCloudStorage()
-		if err != nil {
-			log.Warnf("Failed to load from cloud storage: %s", err.Error())
-			// Fall through to download from MaxMind
-		} else if path != "" {
-			// Successfully loaded from cloud storage
-			return db.openDatabase(path)
-		}
-	}
-
-	// Download from MaxMind (either no cloud storage or cloud storage failed/empty)
-	path, err := db.download()
+// start opens the persisted DB, if any, and returns whether it did so that
+// updates can be checked in the background. Otherwise it blocks until the DB
+// has been fetched.
+func (db *geoIP2DB) start() (bool, error) {
+	db.Lock()
+	ok, err := db.openPersisted()
+	db.Unlock()
 	if err != nil {
-		return err
+		log.Warnf("Failed to open persisted DB: %s", err.Error())
 	}
-	if path == "" {
-		return nil
+	if ok {
+		return true, nil
 	}
-
-	return db.openDatabase(path)
+	err = db.renew()
+	if err != nil {
+		return false, err
+	}
+	db.succeeded()
+	return false, nil
 }
 
-func (db *geoIP2DB) openDatabase(path string) error {
-	log.Infof("Opening DB: %s", path)
-	reader, err := geoip2.Open(path)
-	if err != nil {
-		log.Errorf("Failed to open GeoIP2: %s", err.Error())
-		return err
+func (db *geoIP2DB) close() {
+	// Abort cloud storage calls of a renew in progress
+	if db.cancel != nil {
+		db.cancel()
 	}
-	db.Lock()
-	if db.reader != nil {
-		log.Infof("Closing outdated DB")
-		db.reader.Close()
+	if db.done != nil {
+		close(db.done)
+		db.wg.Wait()
+		db.done = nil
 	}
-	db.reader = reader
+	db.Lock()
+	db.publish(nil)
 	db.Unlock()
-	if db.path != "" {
-		log.Infof("Deleting outdated DB: %s", db.path)
-		os.Remove(db.path)
-	}
-	db.path = path
-	return nil
 }
 
-func (db *geoIP2DB) loadFromCloudStorage() (string, error) {
-	key := fmt.Sprintf("%s.mmdb", db.edition)
-
-	// Check if database exists in cloud storage
-	exists, err := db.cloudStorage.Exists(key)
-	if err != nil {
-		return "", fmt.Errorf("failed to check cloud storage: %w", err)
-	}
-	if !exists {
-		log.Infof("Database not found in cloud storage: %s", key)
-		return "", nil
+// acquire returns the current reader with a reference taken, or nil if no
+// DB has been opened. The caller must release it after use.
+func (db *geoIP2DB) acquire() *mmdbReader {
+	for {
+		reader := db.reader.Load()
+		if reader == nil {
+			return nil
+		}
+		if reader.acquire() {
+			return reader
+		}
+		// The reader was swapped out and drained in the meantime, so a newer
+		// one has been published already.
 	}
+}
 
-	// Get ETag from cloud storage metadata
-	metadata, err := db.cloudStorage.GetMetadata(key)
-	if err != nil {
-		return "", fmt.Errorf("failed to get metadata from cloud storage: %w", err)
+// publish atomically replaces the current reader and releases the outdated
+// one, which is closed once in-flight lookups are done with it.
+func (db *geoIP2DB) publish(reader *mmdbReader) {
+	old := db.reader.Swap(reader)
+	if old != nil {
+		old.release()
 	}
+}
 
-	cloudETag := metadata["etag"]
-	if cloudETag != "" {
-		// Check if ETag has changed since last load
-		if db.etag == cloudETag {
-			log.Infof("Cloud storage ETag unchanged: %s - skipping download", cloudETag)
-			return "", nil // No download needed
-		}
+func (db *geoIP2DB) renew() error {
+	db.Lock()
+	defer db.Unlock()
 
-		log.Infof("Found new ETag in cloud storage: %s (previous: %s)", cloudETag, db.etag)
-		db.etag = cloudETag
+	if db.file != "" {
+		return db.reload()
 	}
-
-	// Download database from cloud storage
-	reader, err := db.cloudStorage.Download(key)
-	if err != nil {
-		return "", fmt.Errorf("failed to download from cloud storage: %w", err)
+	// A pin only holds back updates of a DB being served.
+	if db.pinned != 0 && db.reader.Load() != nil {
+		log.Infof("Skipping renew of %s pinned to generation %d", db.edition, db.pinned)
+		return nil
 	}
-	defer reader.Close()
 
-	// Create temporary file
-	outfile, err := os.CreateTemp("", db.edition)
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+	// If cloud storage is configured, try to load from there first
+	ctx := db.ctx
+	if db.cloudStorage != nil {
+		if db.openFromCloudStorage() {
+			return nil
+		}
+		// Only the replica holding the lease downloads from MaxMind, the
+		// others pick up what it stores.
+		if db.lease != nil {
+			var release func()
+			ctx, release = db.lead()
+			if release == nil {
+				return nil
+			}
+			defer release()
+			// The previous holder may have stored the DB just before the
+			// lease was acquired.
+			if db.openFromCloudStorage() {
+				return nil
+			}
+		}
 	}
-	defer outfile.Close()
 
-	// Copy data to temporary file
-	_, err = io.Copy(outfile, reader)
+	// Download from MaxMind (either no cloud storage or cloud storage failed/empty)
+	etag, modTime := db.etag, db.modTime
+	path, err := db.download(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to copy data from cloud storage: %w", err)
+		return err
+	}
+	if path == "" {
+		return nil
 	}
 
-	// Get modification time
-	modTime, err := db.cloudStorage.GetLastModified(key)
+	path, err = db.openDatabase(path)
 	if err != nil {
-		log.Warnf("Failed to get modification time from cloud storage: %s", err.Error())
-		modTime = time.Now()
+		// Forget the ETag of the rejected DB so that it is fetched again.
+		db.etag, db.modTime = etag, modTime
+		return err
 	}
-	db.modTime = modTime
 
-	log.Infof("Successfully loaded database from cloud storage: %s", outfile.Name())
-	return outfile.Name(), nil
+	// Store in cloud storage if configured
+	if db.cloudStorage != nil {
+		// Another replica may have taken over the lost lease to store its
+		// own download.
+		if ctx.Err() != nil {
+			log.Warnf("Not storing %s in cloud storage without the download lease", db.edition)
+			return nil
+		}
+		epoch := uint(db.reader.Load().Metadata.BuildEpoch)
+		err := db.storeInCloudStorage(path, epoch)
+		if err != nil {
+			log.Errorf("Failed to store in cloud storage: %s", err.Error())
+			// Don't fail the renew, just log the error
+		}
+	}
+	return nil
 }
 
-func (db *geoIP2DB) download() (string, error) {
-	url := fmt.Sprintf("https://download.maxmind.com/app/geoip_download?edition_id=%s&license_key=%s&suffix=tar.gz", db.edition, db.licenseKey)
-	req, err := http.NewRequest("GET", url, nil)
+// openDatabase opens and verifies the DB at path, then swaps it in as the
+// current one and returns its final path. A rejected DB file is deleted
+// unless it is a local file source.
+func (db *geoIP2DB) openDatabase(path string) (string, error) {
+	log.Infof("Opening DB: %s", path)
+	reader, err := maxminddb.Open(path)
 	if err != nil {
-		log.Errorf("Failed to create request: %s", err.Error())
+		log.Errorf("Failed to open GeoIP2: %s", err.Error())
+		if db.file == "" {
+			os.Remove(path)
+		}
 		return "", err
 	}
-	if db.etag != "" {
-		req.Header.Set("If-None-Match", db.etag)
+	err = db.verify(reader)
+	if err != nil {
+		log.Errorf("Rejected DB %s: %s", path, err.Error())
+		reader.Close()
+		if db.file == "" {
+			os.Remove(path)
+		}
+		return "", err
 	}
-	res, err := http.DefaultClient.Do(req)
+	temporary := db.file == ""
+	if temporary && db.dataDir != "" {
+		persisted, err := db.persist(path, reader.Metadata.BuildEpoch)
+		if err != nil {
+			log.Errorf("Failed to persist DB: %s", err.Error())
+		} else {
+			path = persisted
+			temporary = false
+		}
+	}
+	current := newMMDBReader(reader, path, db.modTime, temporary)
+	current.etag = db.etag
+	db.publish(current)
+	return path, nil
+}
+
+func (db *geoIP2DB) download(ctx context.Context) (string, error) {
+	res, err := db.downloader.get(ctx, db.edition, db.licenseKey, "tar.gz", db.etag)
 	if err != nil {
+		log.Errorf("Failed to download %s: %s", db.edition, err.Error())
 		return "", err
 	}
 	defer res.Body.Close()
@@ -294,9 +383,13 @@ func (db *geoIP2DB) download() (string, error) {
 		return "", nil
 	default:
 		log.Errorf("Failed to download %s: %s", db.edition, res.Status)
-		return "", errors.New(res.Status)
+		return "", newStatusError(res)
 	}
-	gr, err := gzip.NewReader(res.Body)
+	// Hash the archive as it streams by so that it can be verified against
+	// the checksum published next to it.
+	hash := sha256.New()
+	body := io.TeeReader(res.Body, hash)
+	gr, err := gzip.NewReader(body)
 	if err != nil {
 		log.Errorf("Failed to read gzip stream: %s", err.Error())
 		return "", err
@@ -315,31 +408,22 @@ func (db *geoIP2DB) download() (string, error) {
 		case tar.TypeDir:
 		case tar.TypeReg:
 			if strings.HasSuffix(header.Name, filename) {
-				outfile, err := os.CreateTemp("", db.edition)
+				outfile, err := db.createTemp()
 				if err != nil {
 					log.Errorf("Failed to create temp file: %s", err.Error())
 					return "", err
 				}
-				defer outfile.Close()
 				log.Infof("Downloading DB: %s => %d bytes", filename, header.Size)
-				_, err = io.CopyN(outfile, tr, header.Size)
+				err = db.extract(ctx, outfile, tr, header.Size, body, hash)
+				outfile.Close()
 				if err != nil {
-					log.Errorf("Failed to copy tar stream: %s", err.Error())
+					// Do not leave partial DBs behind in the data directory.
+					os.Remove(outfile.Name())
 					return "", err
 				}
 				db.etag = res.Header.Get("Etag")
 				db.modTime = header.ModTime
 				log.Infof("Updating etag: %s => %s", filename, db.etag)
-
-				// Store in cloud storage if configured
-				if db.cloudStorage != nil {
-					err := db.storeInCloudStorage(outfile.Name())
-					if err != nil {
-						log.Errorf("Failed to store in cloud storage: %s", err.Error())
-						// Don't fail the download, just log the error
-					}
-				}
-
 				return outfile.Name(), nil
 			} else {
 				_, err := io.CopyN(io.Discard, tr, header.Size)
@@ -356,30 +440,23 @@ func (db *geoIP2DB) download() (string, error) {
 	return "", fmt.Errorf("not found: %s", filename)
 }
 
-func (db *geoIP2DB) storeInCloudStorage(localPath string) error {
-	key := fmt.Sprintf("%s.mmdb", db.edition)
-
-	// Open the local file
-	file, err := os.Open(localPath)
+// extract copies the DB of size bytes out of the tar stream, then verifies
+// the checksum of the whole archive read from body, if enabled.
+func (db *geoIP2DB) extract(ctx context.Context, outfile io.Writer, tr *tar.Reader, size int64, body io.Reader, digest hash.Hash) error {
+	_, err := io.CopyN(outfile, tr, size)
 	if err != nil {
-		return fmt.Errorf("failed to open local file: %w", err)
+		log.Errorf("Failed to copy tar stream: %s", err.Error())
+		return err
 	}
-	defer file.Close()
-
-	// Prepare metadata with ETag
-	metadata := map[string]string{
-		"etag":          db.etag,
-		"edition":       db.edition,
-		"download_time": time.Now().Format(time.RFC3339),
+	if !db.downloader.checksum {
+		return nil
+	}
+	_, err = io.Copy(io.Discard, body)
+	if err == nil {
+		err = db.verifyChecksum(ctx, hex.EncodeToString(digest.Sum(nil)))
 	}
-
-	// Upload to cloud storage
-	log.Infof("Storing database in cloud storage: %s", key)
-	err = db.cloudStorage.UploadWithMetadata(key, file, metadata)
 	if err != nil {
-		return fmt.Errorf("failed to upload to cloud storage: %w", err)
+		log.Errorf("Failed to verify %s: %s", db.edition, err.Error())
 	}
-
-	log.Infof("Successfully stored database in cloud storage with ETag: %s", db.etag)
-	return nil
+	return err
 }
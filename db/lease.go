@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"service/cache"
+	"service/config"
+	"service/log"
+)
+
+// lease is held in Redis by the replica downloading an edition from MaxMind
+// into the shared cloud storage.
+type lease struct {
+	key string
+	ttl time.Duration
+}
+
+// getLeaseTTL returns how long a download lease is held at most, or 0 if
+// leases are disabled. Leases need every replica to share the same Redis.
+func getLeaseTTL() (time.Duration, error) {
+	cfg := config.Get()
+	if !cfg.GetBool("geoip2.lease.enabled") {
+		return 0, nil
+	}
+	if !cache.Ready() {
+		log.Errorf("Download leases need Redis")
+		return 0, errors.New("cache not initialized")
+	}
+	return getDuration("geoip2.lease.ttl", 10*time.Minute)
+}
+
+// lead acquires the download lease of the edition and returns a func to
+// release it, along with a context that is cancelled once the lease is lost.
+// While another replica holds the lease, it waits for the lease to be released
+// or to expire, and returns a nil func once a DB is available without
+// downloading it.
+func (db *geoIP2DB) lead() (context.Context, func()) {
+	for {
+		token, err := cache.Lease(db.ctx, db.lease.key, db.lease.ttl)
+		if err != nil {
+			log.Warnf("Failed to acquire download lease of %s, downloading anyway: %s", db.edition, err.Error())
+			return db.ctx, func() {}
+		}
+		if token != "" {
+			log.Infof("Acquired download lease of %s", db.edition)
+			ctx, cancel := context.WithCancel(db.ctx)
+			stop := db.keepLease(token, cancel)
+			return ctx, func() {
+				stop()
+				cancel()
+				err := cache.Release(context.Background(), db.lease.key, token)
+				if err != nil {
+					log.Warnf("Failed to release download lease of %s: %s", db.edition, err.Error())
+				}
+			}
+		}
+		log.Infof("Waiting for another replica to download %s", db.edition)
+		if !db.waitLease() {
+			return nil, nil
+		}
+		if db.openFromCloudStorage() || db.reader.Load() != nil {
+			return nil, nil
+		}
+		// Nothing has been stored and there is no DB to serve yet, so try to
+		// take over the lease.
+	}
+}
+
+// keepLease extends the lease held with token every third of its TTL until
+// the returned func is called, so that it does not expire while a slow download
+// or upload is still in progress. If the lease cannot be extended, it calls
+// cancel to abort the download, since another replica may take over.
+func (db *geoIP2DB) keepLease(token string, cancel context.CancelFunc) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(db.lease.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			held, err := cache.Extend(db.ctx, db.lease.key, token, db.lease.ttl)
+			if err != nil {
+				log.Warnf("Failed to extend download lease of %s: %s", db.edition, err.Error())
+				cancel()
+				return
+			}
+			if !held {
+				log.Warnf("Lost download lease of %s", db.edition)
+				cancel()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// waitLease polls until the lease is released or expires. It returns false
+// if the DB is closed meanwhile.
+func (db *geoIP2DB) waitLease() bool {
+	deadline := time.Now().Add(db.lease.ttl)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for time.Now().Before(deadline) {
+		select {
+		case <-db.done:
+			return false
+		case <-ticker.C:
+		}
+		held, err := cache.Held(db.ctx, db.lease.key)
+		if err == nil && !held {
+			return true
+		}
+	}
+	return true
+}
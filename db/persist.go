@@ -0,0 +1,156 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"service/log"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// generation is a release of an edition kept in the data directory so that
+// it can be rolled back to.
+type generation struct {
+	Epoch   uint      `json:"epoch"`
+	ETag    string    `json:"etag"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// state is persisted next to the DBs in the data directory so that a restart
+// can serve the persisted copy right away and only ask for updates. The
+// generations are ordered from newest to oldest.
+type state struct {
+	ETag        string        `json:"etag"`
+	Pinned      uint          `json:"pinned,omitempty"`
+	Generations []*generation `json:"generations,omitempty"`
+}
+
+func (db *geoIP2DB) generationPath(epoch uint) string {
+	return filepath.Join(db.dataDir, fmt.Sprintf("%s-%d.mmdb", db.edition, epoch))
+}
+
+func (db *geoIP2DB) statePath() string {
+	return filepath.Join(db.dataDir, fmt.Sprintf("%s.json", db.edition))
+}
+
+// createTemp creates the file a new DB is downloaded to. It lives in the data
+// directory, if any, so that it can be renamed into place atomically.
+func (db *geoIP2DB) createTemp() (*os.File, error) {
+	return os.CreateTemp(db.dataDir, fmt.Sprintf("%s-*.tmp", db.edition))
+}
+
+func (db *geoIP2DB) findGeneration(epoch uint) *generation {
+	for _, g := range db.generations {
+		if g.Epoch == epoch {
+			return g
+		}
+	}
+	return nil
+}
+
+// openPersisted opens the pinned or else the newest DB persisted in the data
+// directory and returns whether there was one.
+func (db *geoIP2DB) openPersisted() (bool, error) {
+	if db.dataDir == "" {
+		return false, nil
+	}
+	st := &state{}
+	data, err := os.ReadFile(db.statePath())
+	if err == nil {
+		err = json.Unmarshal(data, st)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to read persisted DB state: %s", err.Error())
+	}
+	if len(st.Generations) == 0 {
+		log.Infof("No persisted DB: %s", db.edition)
+		return false, nil
+	}
+	pinned := st.Pinned
+	i := slices.IndexFunc(st.Generations, func(g *generation) bool { return g.Epoch == pinned })
+	if i < 0 {
+		pinned, i = 0, 0
+	}
+	err = db.openGeneration(st.Generations[i])
+	if err != nil {
+		// Without a DB to serve the state is moot, and a pin would keep the
+		// DB from being downloaded.
+		return false, err
+	}
+	db.generations = st.Generations
+	db.pinned = pinned
+	db.etag = st.ETag
+	if db.pinned != 0 {
+		log.Warnf("Opened pinned DB generation: %s %d", db.edition, db.pinned)
+	}
+	return true, nil
+}
+
+// openGeneration swaps in a persisted generation. It was verified when it was
+// downloaded, and may be older than the current DB when rolling back.
+func (db *geoIP2DB) openGeneration(g *generation) error {
+	path := db.generationPath(g.Epoch)
+	log.Infof("Opening DB generation: %s", path)
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		log.Errorf("Failed to open GeoIP2: %s", err.Error())
+		return err
+	}
+	db.modTime = g.ModTime
+	current := newMMDBReader(reader, path, g.ModTime, false)
+	current.etag = g.ETag
+	db.publish(current)
+	return nil
+}
+
+// persist moves a newly opened DB into the data directory as the newest
+// generation, prunes the generations beyond the configured number, and
+// returns the persisted path.
+func (db *geoIP2DB) persist(path string, epoch uint) (string, error) {
+	dst := db.generationPath(epoch)
+	if path != dst {
+		err := os.Rename(path, dst)
+		if err != nil {
+			return "", err
+		}
+	}
+	generations := []*generation{{Epoch: epoch, ETag: db.etag, ModTime: db.modTime}}
+	for _, g := range db.generations {
+		if g.Epoch == epoch {
+			continue
+		}
+		if len(generations) >= db.keep && g.Epoch != db.pinned {
+			log.Infof("Deleting outdated DB generation: %s %d", db.edition, g.Epoch)
+			os.Remove(db.generationPath(g.Epoch))
+			continue
+		}
+		generations = append(generations, g)
+	}
+	db.generations = generations
+	return dst, db.saveState()
+}
+
+func (db *geoIP2DB) saveState() error {
+	st := &state{
+		Pinned:      db.pinned,
+		Generations: db.generations,
+	}
+	if len(db.generations) > 0 {
+		st.ETag = db.generations[0].ETag
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := db.statePath() + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, db.statePath())
+}
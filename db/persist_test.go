@@ -0,0 +1,45 @@
+package db
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestStartWithoutPinnedGeneration(t *testing.T) {
+	cloudStorage := newMemoryStorage(t)
+	storeTestDB(t, newCloudTestDB(t, cloudStorage), 1700000000, `"v1"`)
+
+	// The state pins a generation whose file has been removed since.
+	db := newCloudTestDB(t, cloudStorage)
+	db.dataDir = t.TempDir()
+	data, err := json.Marshal(&state{
+		ETag:        `"v0"`,
+		Pinned:      1600000000,
+		Generations: []*generation{{Epoch: 1600000000, ETag: `"v0"`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(db.statePath(), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	persisted, err := db.start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if persisted {
+		t.Fatal("missing generation opened")
+	}
+	if db.pinned != 0 {
+		t.Fatalf("pinned = %d, want 0", db.pinned)
+	}
+	if db.reader.Load() == nil {
+		t.Fatal("no DB served")
+	}
+	if db.etag != `"v1"` {
+		t.Fatalf("etag = %s, want %s", db.etag, `"v1"`)
+	}
+}
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// ErrNotLoaded is returned when no loaded DB supports the requested record type.
+var ErrNotLoaded = errors.New("no database loaded for the requested record type")
+
+// ErrNotFound is returned when the loaded DB has no record for the address.
+// The query functions return it along with the empty record.
+var ErrNotFound = errors.New("no record found for the address")
+
+// recordTypes maps the record types served to the database types that can
+// answer them, in order of preference.
+var recordTypes = map[string][]string{
+	"city":         {"City", "Enterprise", "Country"},
+	"country":      {"Country", "City", "Enterprise"},
+	"asn":          {"ASN", "ISP"},
+	"anonymous-ip": {"Anonymous-IP"},
+}
+
+// Version identifies the DB release that currently answers lookups of the
+// record type, or returns "" if none is loaded. It changes whenever another
+// release is swapped in, so results can be cached under it.
+func Version(record string) string {
+	reader := acquireFor(recordTypes[record])
+	if reader == nil {
+		return ""
+	}
+	defer reader.release()
+	return reader.version()
+}
+
+// acquireFor acquires the first loaded DB whose database type contains one of
+// the given types, in order of preference, or returns nil if there is none.
+func acquireFor(types []string) *mmdbReader {
+	for _, t := range types {
+		for _, db := range dbs {
+			reader := db.acquire()
+			if reader == nil {
+				continue
+			}
+			if strings.Contains(reader.Metadata.DatabaseType, t) {
+				return reader
+			}
+			reader.release()
+		}
+	}
+	return nil
+}
+
+// lookup decodes the record of ip into result from the first loaded DB whose
+// database type contains one of the given types, in order of preference.
+func lookup(types []string, ip net.IP, result interface{}) (*net.IPNet, time.Time, error) {
+	reader := acquireFor(types)
+	if reader == nil {
+		return nil, time.Time{}, ErrNotLoaded
+	}
+	defer reader.release()
+	network, ok, err := reader.LookupNetwork(ip, result)
+	if err == nil && !ok {
+		err = ErrNotFound
+	}
+	return network, reader.modTime, err
+}
+
+// Network returns the network of ip in the DB that answers lookups of the
+// record type, without decoding the record, along with the version of that
+// DB. Results of the network are cached under that version, which may differ
+// from what Version returned a moment ago.
+func Network(record string, ip net.IP) (*net.IPNet, string, error) {
+	reader := acquireFor(recordTypes[record])
+	if reader == nil {
+		return nil, "", ErrNotLoaded
+	}
+	defer reader.release()
+	var skip struct{}
+	network, ok, err := reader.LookupNetwork(ip, &skip)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+	return network, reader.version(), nil
+}
+
+func networkString(network *net.IPNet) string {
+	if network == nil {
+		return ""
+	}
+	return network.String()
+}
+
+type City struct {
+	IP      string `json:"IP"`
+	Network string `json:"Network,omitempty"`
+	Updated string `json:"Updated,omitempty"`
+	*geoip2.City
+}
+
+func QueryCity(ip net.IP) (*City, error) {
+	var res geoip2.City
+	network, modTime, err := lookup(recordTypes["city"], ip, &res)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	return &City{
+		City:    &res,
+		IP:      ip.String(),
+		Network: networkString(network),
+		Updated: modTime.Format(time.RFC1123),
+	}, err
+}
+
+type Country struct {
+	IP      string `json:"IP"`
+	Network string `json:"Network,omitempty"`
+	Updated string `json:"Updated,omitempty"`
+	*geoip2.Country
+}
+
+func QueryCountry(ip net.IP) (*Country, error) {
+	var res geoip2.Country
+	network, modTime, err := lookup(recordTypes["country"], ip, &res)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	return &Country{
+		Country: &res,
+		IP:      ip.String(),
+		Network: networkString(network),
+		Updated: modTime.Format(time.RFC1123),
+	}, err
+}
+
+type ASN struct {
+	IP      string `json:"IP"`
+	Network string `json:"Network,omitempty"`
+	Updated string `json:"Updated,omitempty"`
+	*geoip2.ASN
+}
+
+func QueryASN(ip net.IP) (*ASN, error) {
+	var res geoip2.ASN
+	network, modTime, err := lookup(recordTypes["asn"], ip, &res)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	return &ASN{
+		ASN:     &res,
+		IP:      ip.String(),
+		Network: networkString(network),
+		Updated: modTime.Format(time.RFC1123),
+	}, err
+}
+
+type AnonymousIP struct {
+	IP      string `json:"IP"`
+	Network string `json:"Network,omitempty"`
+	Updated string `json:"Updated,omitempty"`
+	*geoip2.AnonymousIP
+}
+
+func QueryAnonymousIP(ip net.IP) (*AnonymousIP, error) {
+	var res geoip2.AnonymousIP
+	network, modTime, err := lookup(recordTypes["anonymous-ip"], ip, &res)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	return &AnonymousIP{
+		AnonymousIP: &res,
+		IP:          ip.String(),
+		Network:     networkString(network),
+		Updated:     modTime.Format(time.RFC1123),
+	}, err
+}
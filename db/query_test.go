@@ -0,0 +1,22 @@
+package db
+
+import (
+	"math/rand/v2"
+	"net"
+	"testing"
+)
+
+func BenchmarkQueryCity(b *testing.B) {
+	openTestDB(b)
+	b.RunParallel(func(pb *testing.PB) {
+		ip := make(net.IP, net.IPv4len)
+		for pb.Next() {
+			v := rand.Uint32()
+			ip[0], ip[1], ip[2], ip[3] = byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+			_, err := QueryCity(ip)
+			if err != nil && err != ErrNotFound {
+				b.Fatal(err)
+			}
+		}
+	})
+}
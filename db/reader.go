@@ -0,0 +1,67 @@
+package db
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"service/log"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// mmdbReader is a reference counted maxminddb.Reader. The DB holds one
+// reference for as long as the reader is current and every lookup holds
+// another one, so an outdated reader is only closed, and its file deleted if
+// temporary, once the lookups still using it have drained.
+type mmdbReader struct {
+	*maxminddb.Reader
+	path      string
+	etag      string
+	modTime   time.Time
+	temporary bool
+	refs      atomic.Int64
+}
+
+func newMMDBReader(reader *maxminddb.Reader, path string, modTime time.Time, temporary bool) *mmdbReader {
+	r := &mmdbReader{
+		Reader:    reader,
+		path:      path,
+		modTime:   modTime,
+		temporary: temporary,
+	}
+	r.refs.Store(1)
+	return r
+}
+
+// acquire takes a reference unless the reader has already been released by
+// everyone, in which case it is about to be closed and must not be used.
+func (r *mmdbReader) acquire() bool {
+	for {
+		refs := r.refs.Load()
+		if refs <= 0 {
+			return false
+		}
+		if r.refs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
+
+func (r *mmdbReader) release() {
+	if r.refs.Add(-1) != 0 {
+		return
+	}
+	log.Infof("Closing outdated DB: %s", r.path)
+	r.Close()
+	if r.temporary {
+		log.Infof("Deleting outdated DB: %s", r.path)
+		os.Remove(r.path)
+	}
+}
+
+// version identifies the release of the DB by its build time.
+func (r *mmdbReader) version() string {
+	return strconv.FormatUint(uint64(r.Metadata.BuildEpoch), 36)
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"service/log"
+
+	"github.com/robfig/cron/v3"
+)
+
+// backoff computes the delay before retrying a failed renew: exponential from
+// min up to max, with half of it randomized to spread retries of a fleet.
+type backoff struct {
+	min time.Duration
+	max time.Duration
+}
+
+func newBackoff() (*backoff, error) {
+	b := &backoff{}
+	var err error
+	b.min, err = getDuration("geoip2.retry.min", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	b.max, err = getDuration("geoip2.retry.max", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if b.max < b.min {
+		b.max = b.min
+	}
+	return b, nil
+}
+
+func (b *backoff) delay(failures int) time.Duration {
+	delay := b.min
+	// Stop doubling before it can overflow.
+	for i := 1; i < failures && delay < b.max; i++ {
+		if delay >= b.max/2 {
+			delay = b.max
+		} else {
+			delay *= 2
+		}
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// every is a schedule of fixed intervals.
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// splayed delays every run of a schedule by a random amount up to splay, so
+// that a fleet sharing the schedule does not renew all at once.
+type splayed struct {
+	cron.Schedule
+	splay time.Duration
+}
+
+func (s *splayed) Next(t time.Time) time.Time {
+	return s.Schedule.Next(t).Add(rand.N(s.splay))
+}
+
+var friendlySpec = regexp.MustCompile(`^(?:([A-Za-z,\-]+)\s+)?(\d{1,2}):(\d{2})(?:\s+(\S+))?$`)
+
+// parseSchedule parses a renew schedule, which is either a duration such as
+// "24h", a cron expression such as "CRON_TZ=UTC 0 6 * * TUE,FRI", or a week
+// schedule such as "Tue,Fri 06:00 UTC".
+func parseSchedule(spec string, splay time.Duration) (cron.Schedule, error) {
+	var schedule cron.Schedule
+	if du, err := time.ParseDuration(spec); err == nil {
+		if du <= 0 {
+			return nil, fmt.Errorf("invalid renew period: %s", spec)
+		}
+		schedule = every(du)
+	} else if match := friendlySpec.FindStringSubmatch(spec); match != nil {
+		days := match[1]
+		if days == "" {
+			days = "*"
+		}
+		expr := fmt.Sprintf("%s %s * * %s", match[3], match[2], days)
+		if match[4] != "" {
+			expr = fmt.Sprintf("CRON_TZ=%s %s", match[4], expr)
+		}
+		schedule, err = cron.ParseStandard(expr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		schedule, err = cron.ParseStandard(spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if splay > 0 {
+		schedule = &splayed{Schedule: schedule, splay: splay}
+	}
+	return schedule, nil
+}
+
+// statusError is returned for an unexpected HTTP response. It carries the
+// delay requested by a Retry-After header, e.g. along with a 429.
+type statusError struct {
+	status     string
+	retryAfter time.Duration
+}
+
+func newStatusError(res *http.Response) *statusError {
+	err := &statusError{status: res.Status}
+	value := res.Header.Get("Retry-After")
+	if seconds, perr := strconv.Atoi(value); perr == nil {
+		err.retryAfter = time.Duration(seconds) * time.Second
+	} else if t, perr := http.ParseTime(value); perr == nil {
+		err.retryAfter = time.Until(t)
+	}
+	return err
+}
+
+func (e *statusError) Error() string { return e.status }
+
+type renewStatus struct {
+	lastAttempt time.Time
+	lastSuccess time.Time
+	lastError   string
+	failures    int
+	nextRenew   time.Time
+}
+
+func (db *geoIP2DB) succeeded() {
+	db.statusLock.Lock()
+	defer db.statusLock.Unlock()
+	db.status.lastAttempt = time.Now()
+	db.status.lastSuccess = db.status.lastAttempt
+	db.status.lastError = ""
+	db.status.failures = 0
+}
+
+func (db *geoIP2DB) failed(err error) int {
+	db.statusLock.Lock()
+	defer db.statusLock.Unlock()
+	db.status.lastAttempt = time.Now()
+	db.status.lastError = err.Error()
+	db.status.failures++
+	return db.status.failures
+}
+
+func (db *geoIP2DB) setNextRenew(t time.Time) {
+	db.statusLock.Lock()
+	defer db.statusLock.Unlock()
+	db.status.nextRenew = t
+}
+
+// schedule renews the DB on the given schedule, retrying failures with
+// backoff until the next scheduled run. A delay requested by Retry-After is
+// honored even past the next scheduled run. When the persisted DB has been
+// opened, the first renew happens right away. With no schedule, the DB is only
+// renewed once, if persisted, until it succeeds.
+func (db *geoIP2DB) schedule(schedule cron.Schedule, persisted bool) {
+	if schedule == nil && !persisted {
+		return
+	}
+	db.done = make(chan struct{})
+	db.wg.Add(1)
+	go func() {
+		defer db.wg.Done()
+		var next time.Time
+		if persisted {
+			next = time.Now()
+		} else {
+			next = schedule.Next(time.Now())
+		}
+		for {
+			db.setNextRenew(next)
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-db.done:
+				timer.Stop()
+				return
+			case t := <-timer.C:
+				log.Infof("Renewing %s at %s", db.edition, t.String())
+			}
+			err := db.renew()
+			if err == nil {
+				db.succeeded()
+				if schedule == nil {
+					db.setNextRenew(time.Time{})
+					return
+				}
+				next = schedule.Next(time.Now())
+				continue
+			}
+			failures := db.failed(err)
+			delay := db.retry.delay(failures)
+			var statusErr *statusError
+			retryAfter := errors.As(err, &statusErr) && statusErr.retryAfter > 0
+			if retryAfter && statusErr.retryAfter > delay {
+				delay = statusErr.retryAfter
+			}
+			next = time.Now().Add(delay)
+			// The next scheduled run may come sooner than the backoff, but not
+			// sooner than the server asked for.
+			if schedule != nil && !retryAfter {
+				if scheduled := schedule.Next(time.Now()); scheduled.Before(next) {
+					next = scheduled
+				}
+			}
+			log.Errorf("Failed to renew %s (%d consecutive failures), retrying at %s: %s", db.edition, failures, next.Format(time.RFC3339), err.Error())
+		}
+	}()
+}
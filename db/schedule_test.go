@@ -0,0 +1,100 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := &backoff{min: 30 * time.Second, max: time.Hour}
+	for _, tc := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{8, time.Hour},
+		{30, time.Hour},
+		{64, time.Hour},
+		{1 << 20, time.Hour},
+	} {
+		for i := 0; i < 100; i++ {
+			delay := b.delay(tc.failures)
+			if delay < tc.want/2 || delay > tc.want {
+				t.Fatalf("delay(%d) = %s, want between %s and %s", tc.failures, delay, tc.want/2, tc.want)
+			}
+		}
+	}
+}
+
+func TestBackoffDelayMaxDuration(t *testing.T) {
+	b := &backoff{min: time.Second, max: time.Duration(1<<63 - 1)}
+	for _, failures := range []int{1, 32, 62, 63, 64, 100} {
+		if delay := b.delay(failures); delay <= 0 {
+			t.Fatalf("delay(%d) = %s", failures, delay)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		spec string
+		now  time.Time
+		want time.Time
+	}{
+		{"24h", now, now.Add(24 * time.Hour)},
+		{"Tue,Fri 06:00 UTC", now, time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC)},
+		{"Tue,Fri 06:00 UTC", time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC), time.Date(2026, 10, 20, 6, 0, 0, 0, time.UTC)},
+		{"Tue,Fri 06:00 Asia/Taipei", now, time.Date(2026, 10, 15, 22, 0, 0, 0, time.UTC)},
+		{"6:30 UTC", now, time.Date(2026, 10, 15, 6, 30, 0, 0, time.UTC)},
+		{"CRON_TZ=UTC 0 6 * * TUE,FRI", now, time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC)},
+	} {
+		schedule, err := parseSchedule(tc.spec, 0)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %s", tc.spec, err)
+		}
+		if next := schedule.Next(tc.now); !next.Equal(tc.want) {
+			t.Fatalf("Next(%s) of %q = %s, want %s", tc.now, tc.spec, next, tc.want)
+		}
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"25:00",
+		"Tue 06:60 UTC",
+		"Someday 06:00",
+		"06:00 Nowhere/Land",
+		"0s",
+		"-1h",
+	} {
+		if _, err := parseSchedule(spec, 0); err == nil {
+			t.Fatalf("parseSchedule(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestParseScheduleSplay(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	for _, spec := range []string{"24h", "Tue,Fri 06:00 UTC"} {
+		unsplayed, err := parseSchedule(spec, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schedule, err := parseSchedule(spec, 30*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		earliest := unsplayed.Next(now)
+		latest := earliest.Add(30 * time.Minute)
+		for i := 0; i < 100; i++ {
+			next := schedule.Next(now)
+			if next.Before(earliest) || !next.Before(latest) {
+				t.Fatalf("Next(%s) of %q = %s, want in [%s, %s)", now, spec, next, earliest, latest)
+			}
+		}
+	}
+}
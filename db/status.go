@@ -0,0 +1,49 @@
+package db
+
+import "time"
+
+type EditionStatus struct {
+	Edition     string
+	Type        string `json:",omitempty"`
+	Built       string `json:",omitempty"`
+	Updated     string `json:",omitempty"`
+	ETag        string `json:",omitempty"`
+	Schedule    string `json:",omitempty"`
+	LastAttempt string `json:",omitempty"`
+	LastSuccess string `json:",omitempty"`
+	LastError   string `json:",omitempty"`
+	Failures    int
+	NextRenew   string `json:",omitempty"`
+}
+
+// Status reports the loaded DB and the renew status of every edition.
+func Status() []*EditionStatus {
+	statuses := make([]*EditionStatus, len(dbs))
+	for i, db := range dbs {
+		status := &EditionStatus{Edition: db.edition, Schedule: db.renewSpec}
+		reader := db.acquire()
+		if reader != nil {
+			status.Type = reader.Metadata.DatabaseType
+			status.Built = time.Unix(int64(reader.Metadata.BuildEpoch), 0).UTC().Format(time.RFC1123)
+			status.Updated = reader.modTime.Format(time.RFC1123)
+			status.ETag = reader.etag
+			reader.release()
+		}
+		db.statusLock.Lock()
+		status.LastAttempt = formatTime(db.status.lastAttempt)
+		status.LastSuccess = formatTime(db.status.lastSuccess)
+		status.LastError = db.status.lastError
+		status.Failures = db.status.failures
+		status.NextRenew = formatTime(db.status.nextRenew)
+		db.statusLock.Unlock()
+		statuses[i] = status
+	}
+	return statuses
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC1123)
+}
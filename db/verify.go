@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// verifyChecksum compares the SHA256 of a downloaded archive with the one
+// MaxMind publishes next to it.
+func (db *geoIP2DB) verifyChecksum(ctx context.Context, sum string) error {
+	res, err := db.downloader.get(ctx, db.edition, db.licenseKey, "tar.gz.sha256", "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("failed to download checksum: %w", newStatusError(res))
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return fmt.Errorf("empty checksum")
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil || len(fields[0]) != 64 {
+		return fmt.Errorf("invalid checksum: %s", fields[0])
+	}
+	if !strings.EqualFold(fields[0], sum) {
+		return fmt.Errorf("checksum mismatch: %s != %s", sum, fields[0])
+	}
+	return nil
+}
+
+// verify checks a newly opened DB before it replaces the current one: it must
+// be of the configured edition, not older than the current one, and resolve
+// every canary IP.
+func (db *geoIP2DB) verify(reader *maxminddb.Reader) error {
+	// Local files may be named freely, so only downloaded editions are
+	// expected to match their database type.
+	if db.file == "" && reader.Metadata.DatabaseType != db.edition {
+		return fmt.Errorf("database type mismatch: %s != %s", reader.Metadata.DatabaseType, db.edition)
+	}
+	current := db.reader.Load()
+	if current != nil && reader.Metadata.BuildEpoch < current.Metadata.BuildEpoch {
+		return fmt.Errorf("build epoch %s is older than current %s",
+			buildTime(reader).Format(time.RFC3339), buildTime(current.Reader).Format(time.RFC3339))
+	}
+	for _, ip := range db.canaries {
+		var record interface{}
+		_, ok, err := reader.LookupNetwork(ip, &record)
+		if err != nil {
+			return fmt.Errorf("failed to look up canary %s: %w", ip, err)
+		}
+		if !ok {
+			return fmt.Errorf("canary not found: %s", ip)
+		}
+	}
+	return nil
+}
+
+func buildTime(reader *maxminddb.Reader) time.Time {
+	return time.Unix(int64(reader.Metadata.BuildEpoch), 0).UTC()
+}
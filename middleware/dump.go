@@ -81,7 +81,7 @@ func dump(r *http.Request, data []byte, d *responseDumper) error {
 			Method:  r.Method,
 			URI:     r.RequestURI,
 			Proto:   r.Proto,
-			Headers: r.Header,
+			Headers: redact(r.Header),
 		},
 		Response: &response{
 			Code: d.s,
@@ -116,3 +116,13 @@ func dump(r *http.Request, data []byte, d *responseDumper) error {
 	log.Debugf("%s", string(data))
 	return nil
 }
+
+// redact hides credentials from the dumped request headers.
+func redact(header http.Header) http.Header {
+	if header.Get("Authorization") == "" {
+		return header
+	}
+	header = header.Clone()
+	header.Set("Authorization", "[REDACTED]")
+	return header
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"net/http"
+	"service/cache"
 	"service/config"
 	"service/controller"
 	"service/middleware"
@@ -14,6 +15,11 @@ func NewRouter(root http.FileSystem) *mux.Router {
 
 	r := mux.NewRouter()
 
+	// Batch and streaming routes must not go through middleware.Dump, which
+	// buffers the whole request and response bodies.
+	stream := r.PathPrefix(cfg.GetString("endpoint")).Subrouter()
+	stream.Use(middleware.NoCache)
+
 	endpoint := r.PathPrefix(cfg.GetString("endpoint")).Subrouter()
 	endpoint.Use(middleware.Dump)
 	endpoint.Use(middleware.NoCache)
@@ -21,9 +27,33 @@ func NewRouter(root http.FileSystem) *mux.Router {
 	config := &controller.ConfigController{}
 	endpoint.HandleFunc("/version", config.GetVersion).Methods("GET")
 
-	geoip := &controller.GeoIPController{}
+	status := &controller.StatusController{}
+	endpoint.HandleFunc("/status", status.GetStatus).Methods("GET")
+	endpoint.HandleFunc("/status/lookups", status.GetLookupStatus).Methods("GET")
+
+	networks := 100000
+	if cfg.IsSet("cache.networks") {
+		networks = cfg.GetInt("cache.networks")
+	}
+	geoip := &controller.GeoIPController{Cache: cache.Default(), Networks: networks}
 	endpoint.HandleFunc("/city", geoip.City).Methods("GET")
 	endpoint.HandleFunc("/country", geoip.Country).Methods("GET")
+	endpoint.HandleFunc("/asn", geoip.ASN).Methods("GET")
+	endpoint.HandleFunc("/anonymous-ip", geoip.AnonymousIP).Methods("GET")
+	stream.HandleFunc("/{record:city|country|asn|anonymous-ip}/batch", geoip.Batch).Methods("POST")
+	stream.HandleFunc("/{record:city|country|asn|anonymous-ip}/stream", geoip.Stream).Methods("POST")
+
+	// Admin routes are only served when a token is configured. They do not go
+	// through middleware.Dump either, which would log the token.
+	if token := cfg.GetString("admin.token"); token != "" {
+		admin := r.PathPrefix(cfg.GetString("endpoint") + "/admin").Subrouter()
+		admin.Use(middleware.NoCache)
+		admin.Use(middleware.Auth(token))
+		dbs := &controller.DBController{}
+		admin.HandleFunc("/db/{edition}/generations", dbs.GetGenerations).Methods("GET")
+		admin.HandleFunc("/db/{edition}/pin", dbs.Pin).Methods("POST")
+		admin.HandleFunc("/db/{edition}/pin", dbs.Unpin).Methods("DELETE")
+	}
 
 	if root != nil {
 		r.NotFoundHandler = http.FileServer(root)
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// AzureStorage implements CloudStorage for Azure Blob Storage
+type AzureStorage struct {
+	client    *azblob.Client
+	container string
+	keyPrefix
+}
+
+// NewAzureStorage creates a new Azure storage instance. Bucket names the
+// container. It authenticates with the first of these that is configured:
+//   - a connection string, which also works with the Azurite emulator
+//   - a SAS token appended to the service URL
+//   - DefaultAzureCredential, i.e. environment variables, workload or managed
+//     identity, or the Azure CLI
+func NewAzureStorage(config *Config) (*AzureStorage, error) {
+	if config.Bucket == "" {
+		return nil, ErrInvalidConfig
+	}
+
+	var client *azblob.Client
+	var err error
+	switch {
+	case config.ConnectionString != "":
+		client, err = azblob.NewClientFromConnectionString(config.ConnectionString, nil)
+	case config.SASToken != "":
+		serviceURL, uerr := azureServiceURL(config)
+		if uerr != nil {
+			return nil, uerr
+		}
+		client, err = azblob.NewClientWithNoCredential(serviceURL+"?"+strings.TrimPrefix(config.SASToken, "?"), nil)
+	default:
+		serviceURL, uerr := azureServiceURL(config)
+		if uerr != nil {
+			return nil, uerr
+		}
+		cred, cerr := azidentity.NewDefaultAzureCredential(nil)
+		if cerr != nil {
+			return nil, fmt.Errorf("failed to create Azure credential: %w", cerr)
+		}
+		client, err = azblob.NewClient(serviceURL, cred, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure client: %w", err)
+	}
+
+	return &AzureStorage{
+		client:    client,
+		container: config.Bucket,
+		keyPrefix: keyPrefix(config.KeyPrefix),
+	}, nil
+}
+
+// azureServiceURL returns the configured endpoint, or the public endpoint of
+// the storage account
+func azureServiceURL(config *Config) (string, error) {
+	if config.Endpoint != "" {
+		return strings.TrimSuffix(config.Endpoint, "/") + "/", nil
+	}
+	if config.Account == "" {
+		return "", ErrInvalidConfig
+	}
+	return fmt.Sprintf("https://%s.blob.core.windows.net/", config.Account), nil
+}
+
+// UploadWithMetadata uploads data with metadata to Azure
+func (a *AzureStorage) UploadWithMetadata(ctx context.Context, key string, data io.Reader, metadata map[string]string) error {
+	fullKey := a.getFullKey(key)
+
+	options := &azblob.UploadStreamOptions{}
+	if metadata != nil {
+		options.Metadata = make(map[string]*string, len(metadata))
+		for k, v := range metadata {
+			options.Metadata[k] = &v
+		}
+	}
+
+	_, err := a.client.UploadStream(ctx, a.container, fullKey, data, options)
+	if err != nil {
+		return fmt.Errorf("failed to upload to Azure: %w", err)
+	}
+
+	return nil
+}
+
+// Download downloads data from Azure
+func (a *AzureStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	fullKey := a.getFullKey(key)
+
+	res, err := a.client.DownloadStream(ctx, a.container, fullKey, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to download from Azure: %w", err)
+	}
+
+	return res.Body, nil
+}
+
+// Stat retrieves the properties of an Azure blob
+func (a *AzureStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	fullKey := a.getFullKey(key)
+
+	client := a.client.ServiceClient().NewContainerClient(a.container).NewBlobClient(fullKey)
+	props, err := client.GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to get Azure blob properties: %w", err)
+	}
+
+	// Metadata comes back with the canonical header case, e.g. "Etag"
+	info := &ObjectInfo{
+		Metadata: make(map[string]string, len(props.Metadata)),
+	}
+	for k, v := range props.Metadata {
+		if v != nil {
+			info.Metadata[strings.ToLower(k)] = *v
+		}
+	}
+	if props.ContentLength != nil {
+		info.Size = *props.ContentLength
+	}
+	if props.ETag != nil {
+		info.ETag = string(*props.ETag)
+	}
+	if props.LastModified != nil {
+		info.Updated = *props.LastModified
+	}
+	return info, nil
+}
+
+// List returns the keys of the Azure blobs starting with prefix
+func (a *AzureStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	fullPrefix := a.getFullKey(prefix)
+	pager := a.client.NewListBlobsFlatPager(a.container, &azblob.ListBlobsFlatOptions{
+		Prefix: &fullPrefix,
+	})
+	keys := make([]string, 0)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Azure blobs: %w", err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item.Name != nil {
+				keys = append(keys, a.trimKeyPrefix(*item.Name))
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// Delete deletes an Azure blob
+func (a *AzureStorage) Delete(ctx context.Context, key string) error {
+	fullKey := a.getFullKey(key)
+
+	_, err := a.client.DeleteBlob(ctx, a.container, fullKey, nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return fmt.Errorf("failed to delete Azure blob: %w", err)
+	}
+
+	return nil
+}
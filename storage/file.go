@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FileStorage implements CloudStorage on a local directory, such as a shared
+// NFS volume. Metadata is kept in a JSON sidecar next to each object.
+type FileStorage struct {
+	dir string
+	keyPrefix
+}
+
+// NewFileStorage creates a new file storage instance
+func NewFileStorage(config *Config) (*FileStorage, error) {
+	if config.Path == "" {
+		return nil, ErrInvalidConfig
+	}
+
+	err := os.MkdirAll(config.Path, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	return &FileStorage{
+		dir:       config.Path,
+		keyPrefix: keyPrefix(config.KeyPrefix),
+	}, nil
+}
+
+// UploadWithMetadata writes data and its metadata into the directory. Both
+// are written to temporary files first and renamed into place so that readers
+// never see a partial object.
+func (f *FileStorage) UploadWithMetadata(ctx context.Context, key string, data io.Reader, metadata map[string]string) error {
+	path, err := f.getPath(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	err = writeFile(ctx, path, data)
+	if err != nil {
+		return fmt.Errorf("failed to write to file storage: %w", err)
+	}
+
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	sidecar, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	err = writeFile(ctx, metadataPath(path), bytes.NewReader(sidecar))
+	if err != nil {
+		return fmt.Errorf("failed to write metadata to file storage: %w", err)
+	}
+
+	return nil
+}
+
+// Download opens an object in the directory
+func (f *FileStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := f.getPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to read from file storage: %w", err)
+	}
+
+	return file, nil
+}
+
+// Stat retrieves the attributes of an object file and reads its metadata
+// sidecar. The ETag is derived from the modification time and size, like
+// static file servers do.
+func (f *FileStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	path, err := f.getPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	info, err := f.stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{}
+	data, err := os.ReadFile(metadataPath(path))
+	if err == nil {
+		err = json.Unmarshal(data, &metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse metadata from file storage: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read metadata from file storage: %w", err)
+	}
+
+	return &ObjectInfo{
+		Size:     info.Size(),
+		ETag:     fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()),
+		Metadata: metadata,
+		Updated:  info.ModTime(),
+	}, nil
+}
+
+// List returns the sorted keys of the object files starting with prefix,
+// skipping metadata sidecars and unfinished writes
+func (f *FileStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	fullPrefix := f.getFullKey(prefix)
+
+	// Only walk the directory the prefix points into
+	root := f.dir
+	if i := strings.LastIndex(fullPrefix, "/"); i >= 0 {
+		path, err := f.getPath(prefix)
+		if err != nil {
+			return nil, err
+		}
+		root = path
+		if !strings.HasSuffix(fullPrefix, "/") {
+			root = filepath.Dir(path)
+		}
+	}
+
+	keys := make([]string, 0)
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(path, metadataSuffix) || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(f.dir, path)
+		if err != nil {
+			return err
+		}
+		fullKey := filepath.ToSlash(rel)
+		if strings.HasPrefix(fullKey, fullPrefix) {
+			keys = append(keys, f.trimKeyPrefix(fullKey))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list file storage: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Delete removes an object file and its metadata sidecar
+func (f *FileStorage) Delete(ctx context.Context, key string) error {
+	path, err := f.getPath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range []string{path, metadataPath(path)} {
+		err := os.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete from file storage: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (f *FileStorage) stat(path string) (os.FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to stat file storage object: %w", err)
+	}
+
+	return info, nil
+}
+
+// getPath maps the object key, including the key prefix, to a file in the
+// directory. Keys climbing out of the key prefix are rejected as well, even if
+// they stay inside the directory.
+func (f *FileStorage) getPath(key string) (string, error) {
+	fullKey := f.getFullKey(key)
+	rel := filepath.Clean(filepath.FromSlash(fullKey))
+	if slices.Contains(strings.Split(key, "/"), "..") || rel == "." || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object key: %s", fullKey)
+	}
+
+	return filepath.Join(f.dir, rel), nil
+}
+
+const metadataSuffix = ".metadata.json"
+
+// metadataPath returns the path of the metadata sidecar of an object file
+func metadataPath(path string) string {
+	return path + metadataSuffix
+}
+
+// writeFile writes data to a temporary file next to path and renames it into
+// place, unless ctx is done meanwhile
+func writeFile(ctx context.Context, path string, data io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newTestFileStorage(t *testing.T, keyPrefix string) *FileStorage {
+	f, err := NewFileStorage(&Config{Path: t.TempDir(), KeyPrefix: keyPrefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func upload(t *testing.T, cloudStorage CloudStorage, key string, metadata map[string]string) {
+	err := cloudStorage.UploadWithMetadata(context.Background(), key, strings.NewReader(key), metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStorageRejectsEscapingKeys(t *testing.T) {
+	ctx := context.Background()
+	for _, keyPrefix := range []string{"", "geoip2/"} {
+		base := t.TempDir()
+		f, err := NewFileStorage(&Config{Path: filepath.Join(base, "objects"), KeyPrefix: keyPrefix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"../outside.mmdb", "a/../../outside.mmdb", ".."} {
+			err := f.UploadWithMetadata(ctx, key, strings.NewReader("data"), nil)
+			if err == nil {
+				t.Fatalf("uploaded %q with key prefix %q", key, keyPrefix)
+			}
+			if _, err := f.Download(ctx, key); err == nil {
+				t.Fatalf("downloaded %q with key prefix %q", key, keyPrefix)
+			}
+			if _, err := f.Stat(ctx, key); err == nil || errors.Is(err, ErrObjectNotFound) {
+				t.Fatalf("Stat(%q) with key prefix %q = %v", key, keyPrefix, err)
+			}
+			if err := f.Delete(ctx, key); err == nil {
+				t.Fatalf("deleted %q with key prefix %q", key, keyPrefix)
+			}
+		}
+		entries, err := os.ReadDir(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("wrote outside of the directory: %v", entries)
+		}
+	}
+}
+
+func TestFileStorageList(t *testing.T) {
+	f := newTestFileStorage(t, "geoip2/")
+	for _, key := range []string{"a/1.mmdb", "a/b/2.mmdb", "ab/3.mmdb", "c.json"} {
+		upload(t, f, key, map[string]string{"etag": key})
+	}
+	// Neither objects outside of the key prefix nor unfinished writes are
+	// listed.
+	for _, path := range []string{"other/a/4.mmdb", "geoip2/a/5.mmdb.1234.tmp"} {
+		path = filepath.Join(f.dir, filepath.FromSlash(path))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = os.WriteFile(path, nil, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"a/1.mmdb", "a/b/2.mmdb", "ab/3.mmdb", "c.json"}},
+		{"a/", []string{"a/1.mmdb", "a/b/2.mmdb"}},
+		{"a", []string{"a/1.mmdb", "a/b/2.mmdb", "ab/3.mmdb"}},
+		{"a/b/", []string{"a/b/2.mmdb"}},
+		{"a/b/2", []string{"a/b/2.mmdb"}},
+		{"missing/", []string{}},
+	} {
+		keys, err := f.List(ctx, tc.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(keys, tc.want) {
+			t.Fatalf("List(%q) = %v, want %v", tc.prefix, keys, tc.want)
+		}
+	}
+}
+
+func TestFileStorageStat(t *testing.T) {
+	f := newTestFileStorage(t, "geoip2/")
+	upload(t, f, "a/1.mmdb", map[string]string{"epoch": "1"})
+
+	ctx := context.Background()
+	info, err := f.Stat(ctx, "a/1.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len("a/1.mmdb")) || info.Metadata["epoch"] != "1" || info.ETag == "" {
+		t.Fatalf("Stat = %+v", info)
+	}
+
+	_, err = f.Stat(ctx, "a/2.mmdb")
+	if err != ErrObjectNotFound {
+		t.Fatalf("Stat of a missing object = %v, want %v", err, ErrObjectNotFound)
+	}
+	_, err = f.Download(ctx, "a/2.mmdb")
+	if err != ErrObjectNotFound {
+		t.Fatalf("Download of a missing object = %v, want %v", err, ErrObjectNotFound)
+	}
+}
+
+func TestFileStorageDelete(t *testing.T) {
+	f := newTestFileStorage(t, "geoip2/")
+	upload(t, f, "a/1.mmdb", map[string]string{"epoch": "1"})
+	upload(t, f, "a/2.mmdb", nil)
+
+	ctx := context.Background()
+	err := f.Delete(ctx, "a/1.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Stat(ctx, "a/1.mmdb")
+	if err != ErrObjectNotFound {
+		t.Fatalf("Stat of a deleted object = %v, want %v", err, ErrObjectNotFound)
+	}
+	_, err = os.Stat(metadataPath(filepath.Join(f.dir, "geoip2", "a", "1.mmdb")))
+	if !os.IsNotExist(err) {
+		t.Fatalf("metadata of a deleted object left behind: %v", err)
+	}
+	// Deleting a missing object is not an error.
+	err = f.Delete(ctx, "a/1.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := f.Download(ctx, "a/2.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "a/2.mmdb" {
+		t.Fatalf("kept object = %q", data)
+	}
+}
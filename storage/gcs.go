@@ -3,19 +3,19 @@ package storage
 import (
 	"context"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"strings"
-	"time"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
 // GCSStorage implements CloudStorage for Google Cloud Storage
 type GCSStorage struct {
-	client    *storage.Client
-	bucket    string
-	keyPrefix string
+	client *storage.Client
+	bucket string
+	keyPrefix
 }
 
 // NewGCSStorage creates a new GCS storage instance
@@ -36,13 +36,12 @@ func NewGCSStorage(config *Config) (*GCSStorage, error) {
 	return &GCSStorage{
 		client:    client,
 		bucket:    config.Bucket,
-		keyPrefix: config.KeyPrefix,
+		keyPrefix: keyPrefix(config.KeyPrefix),
 	}, nil
 }
 
 // UploadWithMetadata uploads data with metadata to GCS
-func (g *GCSStorage) UploadWithMetadata(key string, data io.Reader, metadata map[string]string) error {
-	ctx := context.Background()
+func (g *GCSStorage) UploadWithMetadata(ctx context.Context, key string, data io.Reader, metadata map[string]string) error {
 	fullKey := g.getFullKey(key)
 
 	obj := g.client.Bucket(g.bucket).Object(fullKey)
@@ -53,6 +52,18 @@ func (g *GCSStorage) UploadWithMetadata(key string, data io.Reader, metadata map
 		writer.Metadata = metadata
 	}
 
+	// Let GCS reject a corrupted upload when the CRC32C can be computed up
+	// front. Readers check it against the stored CRC32C on their own.
+	if seeker, ok := data.(io.ReadSeeker); ok {
+		crc, err := crc32c(seeker)
+		if err != nil {
+			writer.Close()
+			return fmt.Errorf("failed to compute CRC32C: %w", err)
+		}
+		writer.CRC32C = crc
+		writer.SendCRC32C = true
+	}
+
 	// Copy data
 	if _, err := io.Copy(writer, data); err != nil {
 		writer.Close()
@@ -63,8 +74,7 @@ func (g *GCSStorage) UploadWithMetadata(key string, data io.Reader, metadata map
 }
 
 // Download downloads data from GCS
-func (g *GCSStorage) Download(key string) (io.ReadCloser, error) {
-	ctx := context.Background()
+func (g *GCSStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
 	fullKey := g.getFullKey(key)
 
 	obj := g.client.Bucket(g.bucket).Object(fullKey)
@@ -79,9 +89,8 @@ func (g *GCSStorage) Download(key string) (io.ReadCloser, error) {
 	return reader, nil
 }
 
-// GetMetadata retrieves metadata for a GCS object
-func (g *GCSStorage) GetMetadata(key string) (map[string]string, error) {
-	ctx := context.Background()
+// Stat retrieves the attributes of a GCS object
+func (g *GCSStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
 	fullKey := g.getFullKey(key)
 
 	obj := g.client.Bucket(g.bucket).Object(fullKey)
@@ -90,50 +99,60 @@ func (g *GCSStorage) GetMetadata(key string) (map[string]string, error) {
 		if err == storage.ErrObjectNotExist {
 			return nil, ErrObjectNotFound
 		}
-		return nil, fmt.Errorf("failed to get GCS metadata: %w", err)
+		return nil, fmt.Errorf("failed to get GCS object attributes: %w", err)
 	}
 
-	return attrs.Metadata, nil
+	return &ObjectInfo{
+		Size:     attrs.Size,
+		ETag:     attrs.Etag,
+		Metadata: attrs.Metadata,
+		Updated:  attrs.Updated,
+	}, nil
 }
 
-// Exists checks if an object exists in GCS
-func (g *GCSStorage) Exists(key string) (bool, error) {
-	ctx := context.Background()
-	fullKey := g.getFullKey(key)
-
-	obj := g.client.Bucket(g.bucket).Object(fullKey)
-	_, err := obj.Attrs(ctx)
-	if err != nil {
-		if err == storage.ErrObjectNotExist {
-			return false, nil
+// List returns the keys of the GCS objects starting with prefix
+func (g *GCSStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	it := g.client.Bucket(g.bucket).Objects(ctx, &storage.Query{Prefix: g.getFullKey(prefix)})
+	keys := make([]string, 0)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to list GCS objects: %w", err)
 		}
-		return false, fmt.Errorf("failed to check GCS object existence: %w", err)
+		keys = append(keys, g.trimKeyPrefix(attrs.Name))
 	}
 
-	return true, nil
+	return keys, nil
 }
 
-// GetLastModified returns the last modified time of a GCS object
-func (g *GCSStorage) GetLastModified(key string) (time.Time, error) {
-	ctx := context.Background()
+// Delete deletes a GCS object
+func (g *GCSStorage) Delete(ctx context.Context, key string) error {
 	fullKey := g.getFullKey(key)
 
-	obj := g.client.Bucket(g.bucket).Object(fullKey)
-	attrs, err := obj.Attrs(ctx)
-	if err != nil {
-		if err == storage.ErrObjectNotExist {
-			return time.Time{}, ErrObjectNotFound
-		}
-		return time.Time{}, fmt.Errorf("failed to get GCS object attributes: %w", err)
+	err := g.client.Bucket(g.bucket).Object(fullKey).Delete(ctx)
+	if err != nil && err != storage.ErrObjectNotExist {
+		return fmt.Errorf("failed to delete GCS object: %w", err)
 	}
 
-	return attrs.Updated, nil
+	return nil
 }
 
-// getFullKey combines the key prefix with the object key
-func (g *GCSStorage) getFullKey(key string) string {
-	if g.keyPrefix == "" {
-		return key
+// crc32c computes the CRC32C of the rest of r and seeks back
+func crc32c(r io.ReadSeeker) (uint32, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	hash := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	_, err = io.Copy(hash, r)
+	if err != nil {
+		return 0, err
+	}
+	_, err = r.Seek(start, io.SeekStart)
+	if err != nil {
+		return 0, err
 	}
-	return strings.TrimSuffix(g.keyPrefix, "/") + "/" + key
+	return hash.Sum32(), nil
 }
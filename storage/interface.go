@@ -1,45 +1,79 @@
 package storage
 
 import (
-	"errors"
+	"context"
 	"io"
 	"time"
 )
 
-// CloudStorage defines the interface for cloud storage operations
+// CloudStorage defines the interface for cloud storage operations. Methods
+// return ErrObjectNotFound for missing objects.
 type CloudStorage interface {
 	// UploadWithMetadata uploads data with metadata
-	UploadWithMetadata(key string, data io.Reader, metadata map[string]string) error
+	UploadWithMetadata(ctx context.Context, key string, data io.Reader, metadata map[string]string) error
 
-	// Download downloads data from storage
-	Download(key string) (io.ReadCloser, error)
+	// Download downloads data from storage. The context must stay alive until
+	// the reader is closed.
+	Download(ctx context.Context, key string) (io.ReadCloser, error)
 
-	// GetMetadata retrieves metadata for an object
-	GetMetadata(key string) (map[string]string, error)
+	// Stat retrieves the attributes of an object
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
 
-	// Exists checks if an object exists
-	Exists(key string) (bool, error)
+	// List returns the keys of the objects starting with prefix
+	List(ctx context.Context, prefix string) ([]string, error)
 
-	// GetLastModified returns the last modified time of an object
-	GetLastModified(key string) (time.Time, error)
+	// Delete deletes an object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ObjectInfo holds the attributes of a stored object
+type ObjectInfo struct {
+	Size     int64
+	ETag     string // assigned by the storage, unlike the "etag" metadata
+	Metadata map[string]string
+	Updated  time.Time
 }
 
 // Config represents cloud storage configuration
 type Config struct {
-	Provider  string `mapstructure:"provider"` // currently only "gcs" is supported
-	Bucket    string `mapstructure:"bucket"`
-	Region    string `mapstructure:"region"` // for future s3/azure support
-	KeyPrefix string `mapstructure:"key_prefix"`
+	Provider         string   `mapstructure:"provider"` // "gcs", "s3", "azure" or "file"
+	Bucket           string   `mapstructure:"bucket"`   // the container for azure
+	Region           string   `mapstructure:"region"`   // for s3
+	KeyPrefix        string   `mapstructure:"key_prefix"`
+	Endpoint         string   `mapstructure:"endpoint"`          // for S3 compatible stores, e.g. MinIO, or the azure service URL
+	PathStyle        bool     `mapstructure:"path_style"`        // for s3, address buckets by path instead of host
+	Account          string   `mapstructure:"account"`           // for azure, the storage account
+	ConnectionString string   `mapstructure:"connection_string"` // for azure
+	SASToken         string   `mapstructure:"sas_token"`         // for azure
+	Path             string   `mapstructure:"path"`              // for file, the directory to store objects in
+	Timeout          Timeouts `mapstructure:"timeout"`
+}
+
+// Timeouts bound each kind of operation, unless zero
+type Timeouts struct {
+	Stat     time.Duration `mapstructure:"stat"` // also bounds List and Delete
+	Download time.Duration `mapstructure:"download"`
+	Upload   time.Duration `mapstructure:"upload"`
 }
 
 // NewCloudStorage creates a new cloud storage instance based on provider
 func NewCloudStorage(config *Config) (CloudStorage, error) {
+	var cs CloudStorage
+	var err error
 	switch config.Provider {
 	case "gcs":
-		return NewGCSStorage(config)
-	case "s3", "azure":
-		return nil, errors.New("only GCS is currently supported - S3 and Azure are not implemented")
+		cs, err = NewGCSStorage(config)
+	case "s3":
+		cs, err = NewS3Storage(config)
+	case "azure":
+		cs, err = NewAzureStorage(config)
+	case "file":
+		cs, err = NewFileStorage(config)
 	default:
 		return nil, ErrUnsupportedProvider
 	}
+	if err != nil {
+		return nil, err
+	}
+	return withTimeouts(cs, config.Timeout), nil
 }
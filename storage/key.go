@@ -0,0 +1,23 @@
+package storage
+
+import "strings"
+
+// keyPrefix is embedded by the providers to keep their objects under the
+// configured key prefix.
+type keyPrefix string
+
+// getFullKey combines the key prefix with the object key
+func (p keyPrefix) getFullKey(key string) string {
+	if p == "" {
+		return key
+	}
+	return strings.TrimSuffix(string(p), "/") + "/" + key
+}
+
+// trimKeyPrefix strips the key prefix off a full object key
+func (p keyPrefix) trimKeyPrefix(fullKey string) string {
+	if p == "" {
+		return fullKey
+	}
+	return strings.TrimPrefix(fullKey, strings.TrimSuffix(string(p), "/")+"/")
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage implements CloudStorage in memory for tests. Instances
+// do not share their objects.
+type MemoryStorage struct {
+	sync.RWMutex
+	objects map[string]*memoryObject
+	keyPrefix
+}
+
+type memoryObject struct {
+	data     []byte
+	etag     string
+	metadata map[string]string
+	modTime  time.Time
+}
+
+// NewMemoryStorage creates a new in-memory storage instance
+func NewMemoryStorage(config *Config) (*MemoryStorage, error) {
+	return &MemoryStorage{
+		objects:   make(map[string]*memoryObject),
+		keyPrefix: keyPrefix(config.KeyPrefix),
+	}, nil
+}
+
+// UploadWithMetadata stores a copy of data and metadata
+func (m *MemoryStorage) UploadWithMetadata(ctx context.Context, key string, data io.Reader, metadata map[string]string) error {
+	buf, err := io.ReadAll(data)
+	if err != nil {
+		return fmt.Errorf("failed to upload to memory storage: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sum := md5.Sum(buf)
+	obj := &memoryObject{
+		data:     buf,
+		etag:     fmt.Sprintf(`"%x"`, sum),
+		metadata: make(map[string]string, len(metadata)),
+		modTime:  time.Now(),
+	}
+	for k, v := range metadata {
+		obj.metadata[k] = v
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.objects[m.getFullKey(key)] = obj
+	return nil
+}
+
+// Download returns a reader of the stored data
+func (m *MemoryStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := m.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+// Stat returns the attributes and a copy of the metadata of an object
+func (m *MemoryStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	obj, err := m.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string, len(obj.metadata))
+	for k, v := range obj.metadata {
+		metadata[k] = v
+	}
+	return &ObjectInfo{
+		Size:     int64(len(obj.data)),
+		ETag:     obj.etag,
+		Metadata: metadata,
+		Updated:  obj.modTime,
+	}, nil
+}
+
+// List returns the sorted keys of the objects starting with prefix
+func (m *MemoryStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fullPrefix := m.getFullKey(prefix)
+	m.RLock()
+	defer m.RUnlock()
+	keys := make([]string, 0)
+	for fullKey := range m.objects {
+		if strings.HasPrefix(fullKey, fullPrefix) {
+			keys = append(keys, m.trimKeyPrefix(fullKey))
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Delete deletes an object
+func (m *MemoryStorage) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	delete(m.objects, m.getFullKey(key))
+	return nil
+}
+
+func (m *MemoryStorage) get(ctx context.Context, key string) (*memoryObject, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+	obj := m.objects[m.getFullKey(key)]
+	if obj == nil {
+		return nil, ErrObjectNotFound
+	}
+
+	return obj, nil
+}
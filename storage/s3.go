@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3Storage implements CloudStorage for Amazon S3 and S3 compatible stores
+// such as MinIO
+type S3Storage struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+	keyPrefix
+}
+
+// NewS3Storage creates a new S3 storage instance
+func NewS3Storage(config *Config) (*S3Storage, error) {
+	if config.Bucket == "" {
+		return nil, ErrInvalidConfig
+	}
+
+	ctx := context.Background()
+
+	// Use the default credential chain for authentication
+	// This picks up AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY, shared profiles
+	// and IAM roles of EC2, ECS and EKS
+	opts := make([]func(*awsconfig.LoadOptions) error, 0)
+	if config.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(config.Region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		// S3 compatible stores usually ignore the region but it is still
+		// needed for signing
+		cfg.Region = "us-east-1"
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if config.Endpoint != "" {
+			o.BaseEndpoint = aws.String(config.Endpoint)
+		}
+		o.UsePathStyle = config.PathStyle
+	})
+
+	return &S3Storage{
+		client:    client,
+		uploader:  manager.NewUploader(client),
+		bucket:    config.Bucket,
+		keyPrefix: keyPrefix(config.KeyPrefix),
+	}, nil
+}
+
+// UploadWithMetadata uploads data with metadata to S3
+func (s *S3Storage) UploadWithMetadata(ctx context.Context, key string, data io.Reader, metadata map[string]string) error {
+	fullKey := s.getFullKey(key)
+
+	// The uploader streams readers of unknown size in parts
+	_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(fullKey),
+		Body:     data,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload to S3: %w", err)
+	}
+
+	return nil
+}
+
+// Download downloads data from S3
+func (s *S3Storage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	fullKey := s.getFullKey(key)
+
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fullKey),
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to download from S3: %w", err)
+	}
+
+	return out.Body, nil
+}
+
+// Stat retrieves the attributes of an S3 object
+func (s *S3Storage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	fullKey := s.getFullKey(key)
+
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fullKey),
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to get S3 object attributes: %w", err)
+	}
+
+	// S3 returns user metadata keys in lower case, which is how they are
+	// written in the first place
+	return &ObjectInfo{
+		Size:     aws.ToInt64(out.ContentLength),
+		ETag:     aws.ToString(out.ETag),
+		Metadata: out.Metadata,
+		Updated:  aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// List returns the keys of the S3 objects starting with prefix
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.getFullKey(prefix)),
+	})
+	keys := make([]string, 0)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list S3 objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			keys = append(keys, s.trimKeyPrefix(aws.ToString(obj.Key)))
+		}
+	}
+
+	return keys, nil
+}
+
+// Delete deletes an S3 object
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	fullKey := s.getFullKey(key)
+
+	// S3 does not complain about missing objects
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fullKey),
+	})
+	if err != nil && !isS3NotFound(err) {
+		return fmt.Errorf("failed to delete S3 object: %w", err)
+	}
+
+	return nil
+}
+
+// isS3NotFound tells whether err means the object does not exist. HEAD
+// responses carry no body, so they only come with a generic NotFound code.
+func isS3NotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey", "NotFound":
+			return true
+		}
+	}
+	return false
+}
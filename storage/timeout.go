@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// timeoutStorage bounds every operation of the wrapped storage with the
+// configured timeouts
+type timeoutStorage struct {
+	CloudStorage
+	timeouts Timeouts
+}
+
+func withTimeouts(cs CloudStorage, timeouts Timeouts) CloudStorage {
+	if timeouts == (Timeouts{}) {
+		return cs
+	}
+	return &timeoutStorage{CloudStorage: cs, timeouts: timeouts}
+}
+
+// UploadWithMetadata uploads data with metadata within the upload timeout
+func (t *timeoutStorage) UploadWithMetadata(ctx context.Context, key string, data io.Reader, metadata map[string]string) error {
+	if t.timeouts.Upload > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeouts.Upload)
+		defer cancel()
+	}
+	return t.CloudStorage.UploadWithMetadata(ctx, key, data, metadata)
+}
+
+// Download downloads data within the download timeout, which covers reading
+// the returned reader until it is closed
+func (t *timeoutStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	if t.timeouts.Download <= 0 {
+		return t.CloudStorage.Download(ctx, key)
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.timeouts.Download)
+	reader, err := t.CloudStorage.Download(ctx, key)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &cancelReader{ReadCloser: reader, cancel: cancel}, nil
+}
+
+// Stat retrieves the attributes of an object within the stat timeout
+func (t *timeoutStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	if t.timeouts.Stat > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeouts.Stat)
+		defer cancel()
+	}
+	return t.CloudStorage.Stat(ctx, key)
+}
+
+// List returns the keys of objects within the stat timeout
+func (t *timeoutStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	if t.timeouts.Stat > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeouts.Stat)
+		defer cancel()
+	}
+	return t.CloudStorage.List(ctx, prefix)
+}
+
+// Delete deletes an object within the stat timeout
+func (t *timeoutStorage) Delete(ctx context.Context, key string) error {
+	if t.timeouts.Stat > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeouts.Stat)
+		defer cancel()
+	}
+	return t.CloudStorage.Delete(ctx, key)
+}
+
+// cancelReader releases the context of a download once it is closed
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"service/cache"
+	"service/config"
+	"service/db"
+	"service/log"
+	"service/server"
+)
+
+func main() {
+	if err := config.Init(os.Args[1]); err != nil {
+		panic(err)
+	}
+	_ = log.Init()
+	if err := cache.Init(); err != nil {
+		panic(err)
+	}
+	if err := db.Init(); err != nil {
+		panic(err)
+	}
+	defer db.Deinit()
+	ts := httptest.NewServer(server.NewRouter(nil))
+	defer ts.Close()
+	for _, step := range os.Args[2:] {
+		if strings.HasPrefix(step, "sleep") {
+			d, _ := time.ParseDuration(strings.TrimPrefix(step, "sleep"))
+			time.Sleep(d)
+			continue
+		}
+		if strings.HasPrefix(step, "burst ") {
+			var n int
+			var path string
+			fmt.Sscanf(step, "burst %d %s", &n, &path)
+			var wg sync.WaitGroup
+			codes := make([]int, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					res, err := ts.Client().Get(ts.URL + path)
+					if err == nil {
+						io.Copy(io.Discard, res.Body)
+						res.Body.Close()
+						codes[i] = res.StatusCode
+					}
+				}(i)
+			}
+			wg.Wait()
+			fmt.Println(">> burst", n, path, codes[0], codes[n-1])
+			continue
+		}
+		parts := strings.SplitN(step, " ", 3)
+		var body io.Reader
+		if len(parts) > 2 {
+			body = strings.NewReader(strings.ReplaceAll(parts[2], `\n`, "\n"))
+		}
+		req, _ := http.NewRequest(parts[0], ts.URL+parts[1], body)
+		req.Header.Set("Authorization", "Bearer t0k")
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			fmt.Println("ERR", err)
+			continue
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		fmt.Printf(">> %s %s => %d %s\n", parts[0], parts[1], res.StatusCode, strings.TrimSpace(string(b)))
+	}
+}